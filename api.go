@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// writeJson serializes v as the JSON body of the response.
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write response,", err)
+	}
+}
+
+// intParam returns the integer value of a query parameter, or def if the
+// parameter is missing or invalid.
+func intParam(r *http.Request, name string, def int) int {
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// handleArticles serves the versions of all current articles, see
+// Codex.Articles():
+//    GET /api/articles
+func (col *Collection) handleArticles(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, col.Codex.Articles())
+}
+
+// handleArticle serves the current <article> of an input, eg for clients
+// catching up after a lost connection:
+//    GET /api/article?source=<path>
+func (col *Collection) handleArticle(w http.ResponseWriter, r *http.Request) {
+	article, err := col.Codex.ArticleHtml(r.URL.Query().Get("source"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+	w.Write([]byte(article))
+}
+
+// handleHistory serves past versions of a single node:
+//    GET /api/history?node=<id>[&limit=<n>]
+func (col *Collection) handleHistory(w http.ResponseWriter, r *http.Request) {
+	cdx := col.Codex
+	if cdx.history == nil {
+		http.Error(w, "history is disabled, see -history", http.StatusNotFound)
+		return
+	}
+	ref, err := cdx.LookupNode(r.URL.Query().Get("node"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	limit := intParam(r, "limit", HistoryMaxRevisions)
+	revs, err := cdx.history.NodeHistory(cdx, ref, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"node":      r.URL.Query().Get("node"),
+		"source":    ref.Source.Path,
+		"head":      ref.HeadPath,
+		"revisions": revs,
+	})
+}
+
+// handleRevision serves a past version of an input as a codex <article>:
+//    GET /api/revision?source=<path>&commit=<sha>
+func (col *Collection) handleRevision(w http.ResponseWriter, r *http.Request) {
+	cdx := col.Codex
+	if cdx.history == nil {
+		http.Error(w, "history is disabled, see -history", http.StatusNotFound)
+		return
+	}
+	codoc, ok := cdx.Inputs[r.URL.Query().Get("source")]
+	if !ok {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+	rev, err := cdx.history.Revision(codoc, r.URL.Query().Get("commit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	doc, err := cdx.history.Render(cdx, codoc, rev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	article := doc.Find("body").Clone()
+	article.Get(0).Data = "article"
+	article.SetAttr("codex-source", codoc.Path)
+	article.SetAttr("codex-commit", rev.Commit)
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+	w.Write([]byte(OuterHtml(article)))
+}
+
+// handleNode serves a node subtree converted to one of the NodeFormats:
+//    GET /api/node?id=<id>&format=<markdown|plain|html>
+func (col *Collection) handleNode(w http.ResponseWriter, r *http.Request) {
+	ref, err := col.Codex.LookupNode(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	contentType, ok := NodeFormats[format]
+	if !ok {
+		http.Error(w, "unknown format: "+format, http.StatusBadRequest)
+		return
+	}
+	// for markdown inputs, the original source is the best markdown there is
+	ext := filepath.Ext(ref.Source.Path)
+	if format == "markdown" && (ext == ".md" || ext == ".markdown") {
+		if span, ok := SourceSpan(ref.Source.Path, ref.Mtime, ref.Node); ok {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(span))
+			return
+		}
+	}
+
+	converted, err := col.Codex.ConvertNode(ref.Node, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+	w.Write([]byte(converted))
+}
+
+// handleSource reads and writes the source text of nodes, if editing is
+// enabled, see Codex.PatchSource():
+//    GET /api/source?node=<id>   responds with a SourceEdit
+//    POST /api/source            accepts a SourceEdit as JSON
+func (col *Collection) handleSource(w http.ResponseWriter, r *http.Request) {
+	cdx := col.Codex
+	if !cdx.Config.Edit {
+		http.Error(w, "editing is disabled, see -edit", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ref, err := cdx.LookupNode(r.URL.Query().Get("node"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		start, end, ok := SourceLines(ref.Node)
+		text, fresh := SourceSpan(ref.Source.Path, ref.Mtime, ref.Node)
+		if !ok || !fresh {
+			http.Error(w, "node source is unknown or stale", http.StatusConflict)
+			return
+		}
+		writeJson(w, SourceEdit{
+			Source: ref.Source.Path,
+			Start:  start,
+			End:    end,
+			Text:   text,
+			Mtime:  ref.Mtime,
+			Hash:   SourceHash(text),
+		})
+	case http.MethodPost:
+		// only accepting JSON means cross-origin forms can't post here
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var edit SourceEdit
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cdx.PatchSource(edit); err == ErrConflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println("edited:", edit.Source, "lines", edit.Start, "-", edit.End)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSearch serves ranked search over nodes, optionally filtered by front
+// matter, see Codex.Search() and ParseQuery():
+//    GET /api/search?q=<query>[&limit=<n>][&<meta key>=<value> ...]
+func (col *Collection) handleSearch(w http.ResponseWriter, r *http.Request) {
+	filters := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if key != "q" && key != "limit" {
+			filters[key] = values[0]
+		}
+	}
+	query := r.URL.Query().Get("q")
+	results, err := col.Codex.Search(query, filters, intParam(r, "limit", SearchMaxHits))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, results)
+}
+
+// handleTags serves the inline tag index, see Codex.TagIndex():
+//    GET /api/tags
+func (col *Collection) handleTags(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, col.Codex.TagIndex())
+}
+
+// handleTasks serves the open tasks across all inputs, or all of them
+// including those that are done, see Codex.TaskList():
+//    GET /api/tasks[?all=1]
+func (col *Collection) handleTasks(w http.ResponseWriter, r *http.Request) {
+	all := r.URL.Query().Get("all") != ""
+	writeJson(w, col.Codex.TaskList(all))
+}
+
+// handleTimeline serves journal entries across all inputs, most recent first,
+// optionally within an inclusive date range, see Codex.Timeline(). With
+// format=html it serves the entries themselves, see Codex.TimelineArticle():
+//    GET /api/timeline[?from=<yyyy-mm-dd>][&to=<yyyy-mm-dd>][&format=html]
+func (col *Collection) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	if !col.Codex.Config.Journal {
+		http.Error(w, "journal mode is disabled, see -journal", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+		w.Write([]byte(col.Codex.TimelineArticle(query.Get("from"), query.Get("to"))))
+		return
+	}
+	writeJson(w, col.Codex.Timeline(query.Get("from"), query.Get("to")))
+}
+
+// handleAsset serves files referenced by inputs, eg images, see
+// Codex.ReadAsset():
+//    GET /api/asset?source=<path>&path=<relative path>
+func (col *Collection) handleAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("path")
+	contents, err := col.Codex.ReadAsset(r.URL.Query().Get("source"), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// content type is inferred from the extension of name, eg an svg could
+	// otherwise run scripts when opened directly.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	http.ServeContent(w, r, filepath.Base(name), time.Time{}, bytes.NewReader(contents))
+}
+
+// handleTree serves the node tree, see Codex.Tree(), of the whole codex, one
+// input, or one node subtree:
+//    GET /api/tree[?source=<path>|?node=<id>]
+func (col *Collection) handleTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tree, err := col.Codex.Tree(ExportScope{Source: query.Get("source"), Node: query.Get("node")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, tree)
+}
+
+// handleExport serves the whole codex, one input, or one node subtree as one
+// of the ExportFormats, see Codex.Export():
+//    GET /export?format=<html|json|pdf|markdown|org|docx>[&source=<path>|&node=<id>]
+func (col *Collection) handleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "html"
+	}
+	exportFormat, ok := ExportFormats[format]
+	if !ok {
+		http.Error(w, "unknown format: "+format, http.StatusBadRequest)
+		return
+	}
+	scope := ExportScope{Source: query.Get("source"), Node: query.Get("node")}
+	if _, ok := col.Codex.Inputs[scope.Source]; scope.Source != "" && !ok {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+	if scope.Node != "" {
+		if _, err := col.Codex.LookupNode(scope.Node); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	exported, err := col.Codex.Export(scope, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", exportFormat.ContentType)
+	if format == "html" {
+		w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+	} else {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="codex%s"`, exportFormat.Ext))
+	}
+	w.Write(exported)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// authCookie remembers a token given in the URL, see Auth.Wrap().
+	authCookie = "codex-token"
+)
+
+type accessLevel int
+
+const (
+	accessNone accessLevel = iota
+	accessRead             // safe methods only, eg GET
+	accessFull
+)
+
+// Auth guards all HTTP endpoints of a codex server, see Wrap(). A zero Auth
+// lets everyone in.
+type Auth struct {
+	// Token grants full access, ReadToken grants read-only access, ie no
+	// edits. Either is accepted as a bearer token, eg in the websocket
+	// handshake, as a "token" query parameter, or as a cookie.
+	Token     string
+	ReadToken string
+
+	// Users are bcrypt password hashes by user name, for basic auth, see
+	// LoadHtpasswd(). Users have full access.
+	Users map[string][]byte
+}
+
+// Enabled reports whether any credentials are configured.
+func (auth *Auth) Enabled() bool {
+	return auth.Token != "" || auth.ReadToken != "" || len(auth.Users) > 0
+}
+
+// LoadHtpasswd reads user names and password hashes from an htpasswd file.
+// Only bcrypt hashes are supported, eg as created by `htpasswd -B`.
+func LoadHtpasswd(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "$2") {
+			return nil, errors.New(fmt.Sprintf(
+				"%s:%d: expected user:bcrypt-hash, see htpasswd -B", path, lineno,
+			))
+		}
+		users[parts[0]] = []byte(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Wrap returns a handler that only lets authenticated requests through to
+// next. Unauthenticated requests are rejected with 401, and requests with
+// read-only access that are not GET or HEAD with 403.
+//
+// A token in the query string of a page request, eg / or /c/notes/, is moved
+// to a cookie, such that subsequent requests of the client, including its
+// websocket, are authenticated too, and the token does not linger in the
+// address bar.
+func (auth *Auth) Wrap(next http.Handler) http.Handler {
+	if !auth.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access := auth.access(r)
+		if access == accessNone {
+			if len(auth.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="codex"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if access == accessRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read-only access", http.StatusForbidden)
+			return
+		}
+
+		query := r.URL.Query()
+		if token := query.Get("token"); token != "" && r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/") {
+			http.SetCookie(w, &http.Cookie{
+				Name:     authCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			query.Del("token")
+			target := *r.URL
+			target.RawQuery = query.Encode()
+			http.Redirect(w, r, target.String(), http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// access returns the best access level any of the request's credentials
+// grant.
+func (auth *Auth) access(r *http.Request) accessLevel {
+	var tokens []string
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		tokens = append(tokens, strings.TrimPrefix(header, "Bearer "))
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		tokens = append(tokens, token)
+	}
+	if cookie, err := r.Cookie(authCookie); err == nil {
+		tokens = append(tokens, cookie.Value)
+	}
+
+	best := accessNone
+	for _, token := range tokens {
+		if tokenEqual(token, auth.Token) {
+			return accessFull
+		}
+		if tokenEqual(token, auth.ReadToken) {
+			best = accessRead
+		}
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		if hash, ok := auth.Users[user]; ok && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return accessFull
+		}
+	}
+	return best
+}
+
+// tokenEqual compares a given token to a configured one in constant time, an
+// unconfigured token matches nothing.
+func tokenEqual(given string, configured string) bool {
+	return configured != "" && subtle.ConstantTimeCompare([]byte(given), []byte(configured)) == 1
+}
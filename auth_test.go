@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Auth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	auth := Auth{
+		Token:     "full",
+		ReadToken: "read",
+		Users:     map[string][]byte{"alice": hash},
+	}
+	handler := auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(httptest.NewRequest("GET", "/api/tags", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="codex"`, rec.Header().Get("WWW-Authenticate"))
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Authorization", "Bearer full")
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+
+	req = httptest.NewRequest("POST", "/api/source", nil)
+	req.AddCookie(&http.Cookie{Name: authCookie, Value: "read"})
+	assert.Equal(t, http.StatusForbidden, serve(req).Code)
+	req = httptest.NewRequest("GET", "/api/source", nil)
+	req.AddCookie(&http.Cookie{Name: authCookie, Value: "read"})
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+
+	req = httptest.NewRequest("POST", "/api/source", nil)
+	req.SetBasicAuth("alice", "secret")
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+	req.SetBasicAuth("alice", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serve(req).Code)
+
+	rec = serve(httptest.NewRequest("GET", "/?token=read&q=x", nil))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/?q=x", rec.Header().Get("Location"))
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), authCookie+"=read")
+}
+
+func Test_Auth_disabled(t *testing.T) {
+	var auth Auth
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.False(t, auth.Enabled())
+	rec := httptest.NewRecorder()
+	auth.Wrap(handler).ServeHTTP(rec, httptest.NewRequest("POST", "/api/source", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_IsLoopback(t *testing.T) {
+	assert.True(t, IsLoopback("127.0.0.1:8000"))
+	assert.True(t, IsLoopback("localhost:8000"))
+	assert.True(t, IsLoopback("[::1]:8000"))
+	assert.False(t, IsLoopback(":8000"))
+	assert.False(t, IsLoopback("0.0.0.0:8000"))
+}
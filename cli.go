@@ -1,8 +1,173 @@
 package main
 
-import "os"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
 
 func main() {
-	inputs := os.Args[1:]
-	NewServer(inputs, ":8000").Start()
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportMain(os.Args[2:])
+		return
+	}
+
+	var conf Config
+	var tagPatterns, dateFormats, collections stringList
+	addr := flag.String("addr", "127.0.0.1:8000", "address to serve codex on, eg :8000 for all interfaces")
+	flag.BoolVar(&conf.History, "history", false, "serve git history of inputs")
+	flag.BoolVar(&conf.Edit, "edit", false, "allow editing inputs from the browser")
+	flag.StringVar(&conf.EditorUrl, "editor-url", "",
+		"template for open-in-editor links, eg vscode://file/{path}:{line}")
+	flag.BoolVar(&conf.Journal, "journal", false, "merge dated entries into a timeline")
+	flag.Var(&dateFormats, "date-format",
+		"Go time layout of dates in headings, repeatable, default: 2006-01-02")
+	heads := flag.String("heads", "", "YAML file of head rules per file extension")
+	flag.Var(&tagPatterns, "tag-pattern",
+		"regular expression for inline tags, repeatable, default: #tag and @mention")
+	flag.StringVar(&conf.Sanitize, "sanitize", "auto",
+		"HTML sanitization of inputs: strict, relaxed, off, or auto: relaxed on loopback addresses, strict otherwise")
+	var auth Auth
+	flag.StringVar(&auth.Token, "token", "", "require this token for full access")
+	flag.StringVar(&auth.ReadToken, "read-token", "", "accept this token for read-only access")
+	htpasswd := flag.String("htpasswd", "", "htpasswd file of users for basic auth, bcrypt only")
+	tlsCert := flag.String("tls-cert", "", "certificate file to serve HTTPS with, requires -tls-key")
+	tlsKey := flag.String("tls-key", "", "private key file of -tls-cert")
+	flag.Var(&collections, "collection",
+		"serve a named collection of inputs at /c/<name>/, eg runbooks=ops/*.md,faq.rst, repeatable")
+	themeName := flag.String("theme", "light", "built-in theme: light, dark, or auto")
+	themeDir := flag.String("theme-dir", "", "directory of a custom theme: template.html, CSS, and JS files")
+	flag.Parse()
+
+	if *heads != "" {
+		rules, err := LoadHeadRules(*heads)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.HeadRules = rules
+	}
+	if conf.Sanitize == "auto" {
+		conf.Sanitize = DefaultSanitizePolicy(*addr)
+	}
+	log.Println("Sanitizing inputs with the", conf.Sanitize, "policy")
+	conf.DateFormats = dateFormats
+	conf.TagPatterns = DefaultTagPatterns
+	if len(tagPatterns) > 0 {
+		conf.TagPatterns = tagPatterns
+	}
+
+	theme, err := LoadTheme(*themeName, *themeDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.Theme = theme
+	if *htpasswd != "" {
+		users, err := LoadHtpasswd(*htpasswd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auth.Users = users
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key go together")
+	}
+
+	srv := NewServer(*addr, conf)
+	srv.Auth = auth
+	srv.TLSCert, srv.TLSKey = *tlsCert, *tlsKey
+	if len(collections) == 0 {
+		if err := srv.AddCollection("", flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+	} else if flag.NArg() > 0 {
+		log.Fatal("inputs go in -collection when there are collections")
+	}
+	for _, spec := range collections {
+		name, paths, err := parseCollection(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := srv.AddCollection(name, paths); err != nil {
+			log.Fatal(err)
+		}
+	}
+	srv.Start()
+}
+
+// exportMain is the export subcommand, see Codex.Export():
+//    codex export [-format json|pdf|markdown|org|docx] [-source notes.md | -node <id>] [-o notes.pdf] inputs...
+func exportMain(args []string) {
+	var conf Config
+	var scope ExportScope
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "html",
+		"export format: html, json, markdown, org, docx, or pdf if a LaTeX engine is installed")
+	flags.StringVar(&scope.Source, "source", "", "export only this input")
+	flags.StringVar(&scope.Node, "node", "", "export only the node with this id")
+	output := flags.String("o", "", "file to write the export to, default: stdout")
+	heads := flags.String("heads", "", "YAML file of head rules per file extension")
+	flags.StringVar(&conf.Sanitize, "sanitize", "relaxed", "HTML sanitization of inputs: strict, relaxed, or off")
+	flags.Parse(args)
+
+	if *heads != "" {
+		rules, err := LoadHeadRules(*heads)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.HeadRules = rules
+	}
+	conf.TagPatterns = DefaultTagPatterns
+	cdx, err := NewCodex(flags.Args(), conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exported, err := cdx.Export(scope, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(exported)
+		return
+	}
+	if err := ioutil.WriteFile(*output, exported, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseCollection parses a -collection flag, eg "notes=notes/*.md,todo.org",
+// into a name and the paths of its inputs. Glob patterns are expanded.
+func parseCollection(spec string) (string, []string, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", nil, errors.New(fmt.Sprintf("Expected -collection name=path,...: %s", spec))
+	}
+	var paths []string
+	for _, pattern := range strings.Split(parts[1], ",") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(matches) == 0 {
+			matches = []string{pattern} // let it fail like any missing input
+		}
+		paths = append(paths, matches...)
+	}
+	return parts[0], paths, nil
 }
@@ -4,8 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
 	"golang.org/x/sync/errgroup"
+	"io/ioutil"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -18,11 +25,39 @@ type Codex struct {
 	Inputs  map[string]*Document
 	HtmlDoc *goquery.Document
 	HtmlStr string
+	Config  Config
+
+	// Meta holds the front matter of inputs, keyed by path.
+	Meta map[string]Metadata
+	// Tags holds the inline tag index of inputs, keyed by path, see TagIndex().
+	Tags map[string]map[string][]TagRef
+	// Tasks holds the tasks in inputs, keyed by path, see TaskList().
+	Tasks map[string][]Task
+	// Entries holds the journal entries of inputs, keyed by path, see Timeline().
+	Entries map[string][]TimelineEntry
+	// SearchIndex holds the searchable nodes of inputs, keyed by path, see
+	// SearchIndex().
+	SearchIndex map[string][]SearchEntry
+	// Assets holds the names of the assets referenced by inputs, keyed by
+	// path, see LinkAssets().
+	Assets map[string]map[string]bool
 
 	pandocPool *PandocPool
+	// pandocSlots limits pandoc subprocesses, in and out of the pool, to
+	// PandocConcurrency, see pandocSlot().
+	pandocSlots chan struct{}
+	history     *History
+	tagger      *Tagger
+	sanitizer   *SanitizePolicy
+	stats       *BuildStats
+
+	// mu guards HtmlDoc and HtmlStr against concurrent builds and readers.
+	mu sync.RWMutex
+	// editMu serializes writes to input files, see PatchSource().
+	editMu sync.Mutex
 }
 
-func NewCodex(paths []string) (*Codex, error) {
+func NewCodex(paths []string, conf Config) (*Codex, error) {
 	if len(paths) == 0 {
 		return nil, errors.New("Need at least one input")
 	}
@@ -33,9 +68,31 @@ func NewCodex(paths []string) (*Codex, error) {
 	}
 
 	cdx := Codex{
-		Inputs:     codocs,
-		pandocPool: NewPandocPool(PandocConcurrency),
+		Inputs:      codocs,
+		Config:      conf,
+		Meta:        make(map[string]Metadata),
+		Tags:        make(map[string]map[string][]TagRef),
+		Tasks:       make(map[string][]Task),
+		Entries:     make(map[string][]TimelineEntry),
+		SearchIndex: make(map[string][]SearchEntry),
+		Assets:      make(map[string]map[string]bool),
+		pandocPool:  NewPandocPool(PandocConcurrency),
+		pandocSlots: make(chan struct{}, PandocConcurrency),
+		stats:       NewBuildStats(),
+	}
+	if conf.History {
+		cdx.history = NewHistory()
+	}
+	tagger, err := NewTagger(conf.TagPatterns)
+	if err != nil {
+		return nil, err
 	}
+	cdx.tagger = tagger
+	sanitizer, err := LookupSanitizePolicy(conf.Sanitize)
+	if err != nil {
+		return nil, err
+	}
+	cdx.sanitizer = sanitizer
 
 	doc, err := cdx.DOMSkeleton()
 	if err != nil {
@@ -52,25 +109,52 @@ func NewCodex(paths []string) (*Codex, error) {
 	return &cdx, nil
 }
 
-// DOMSkeleton loads the codex HTML template and creates stand-in
+// DOMSkeleton loads the codex HTML template of the theme and creates stand-in
 // <article> elements in <main> for each of the input Documents.
-//    <html> ... <body>
-//      <main>
-//        <article codex-source="example.md" ...> </article>
-//        <article codex-source="other.rst" ...> </article>
-//        ...
-//      </main>
-//    </body> </html>
+//
+//	<html> ... <body>
+//	  <main>
+//	    <article codex-source="example.md" ...> </article>
+//	    <article codex-source="other.rst" ...> </article>
+//	    ...
+//	  </main>
+//	</body> </html>
 func (cdx *Codex) DOMSkeleton() (*goquery.Document, error) {
-	doc, err := LoadHtml(CodexOutputTemplate)
+	page, err := cdx.Config.theme().Page()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := LoadHtml(page)
 	if err != nil {
 		return nil, err
 	}
 
+	// client-side features that depend on server configuration:
+	if cdx.Config.History {
+		doc.Find("head").AppendHtml(`<meta name="codex-history" content="on"/>`)
+	}
+	if cdx.Config.Journal {
+		doc.Find("head").AppendHtml(`<meta name="codex-journal" content="on"/>`)
+	}
+	if cdx.Config.Edit {
+		doc.Find("head").AppendHtml(`<meta name="codex-edit" content="on"/>`)
+	}
+	doc.Find("head").AppendHtml(`<meta name="codex-boot"/>`)
+	doc.Find(`meta[name="codex-boot"]`).SetAttr("content", bootId)
+	if cdx.Config.EditorUrl != "" {
+		doc.Find("head").AppendHtml(`<meta name="codex-editor-url"/>`)
+		doc.Find(`meta[name="codex-editor-url"]`).SetAttr("content", cdx.Config.EditorUrl)
+	}
+
 	main := doc.Find("main")
 
 	for _, codoc := range cdx.Inputs {
+		absPath, err := filepath.Abs(codoc.Path)
+		if err != nil {
+			return nil, err
+		}
 		main.AppendHtml(fmt.Sprintf(`<article codex-source="%s"/>`, codoc.Path))
+		main.Children().Last().SetAttr("codex-path", absPath)
 	}
 	return doc, nil
 }
@@ -88,28 +172,111 @@ func (cdx *Codex) CurrentDOMArticle(codoc *Document) *goquery.Selection {
 
 // Update rebuilds the specified document and updates its DOM <article>.
 func (cdx *Codex) Update(codoc *Document) (string, error) {
-	article := cdx.CurrentDOMArticle(codoc)
-	innerHtml, err := cdx.Transform(codoc)
+	start := time.Now()
+	cdx.stats.started(codoc.Path)
+	// note: taken before reading the file, such that a change during the
+	// build is picked up by the next one. The Document is only touched by
+	// builds, readers go by the codex-mtime of articles, see NodeRef.
+	mtime := codoc.CheckMtime()
+	codoc.SetBtime()
+	innerHtml, meta, assets, err := cdx.Transform(codoc)
+	cdx.stats.finished(codoc.Path, time.Since(start), err)
 	if err != nil {
 		return "", err
 	}
+
+	cdx.mu.Lock()
+	defer cdx.mu.Unlock()
+	article := cdx.CurrentDOMArticle(codoc)
 	article.SetHtml(innerHtml)
-	article.SetAttr("codex-mtime", ToIso8601(codoc.Mtime))
+	article.SetAttr("codex-mtime", ToIso8601(mtime))
+
+	var attrs []html.Attribute
+	for _, attr := range article.Get(0).Attr {
+		if !strings.HasPrefix(attr.Key, "codex-") || articleAttrs[attr.Key] {
+			attrs = append(attrs, attr) // drop stale metadata
+		}
+	}
+	article.Get(0).Attr = attrs
+	for name, value := range meta.Attrs() {
+		article.SetAttr(name, value)
+	}
+	cdx.Meta[codoc.Path] = meta
+	cdx.Tags[codoc.Path] = TagIndex(article)
+	cdx.Tasks[codoc.Path] = TaskList(article, cdx.Config.dateFormats())
+	cdx.Entries[codoc.Path] = Timeline(article)
+	cdx.SearchIndex[codoc.Path] = SearchIndex(article, cdx.Config.dateFormats())
+	cdx.Assets[codoc.Path] = assets
+
 	cdx.HtmlStr = DocToHtml(cdx.HtmlDoc)
 	return OuterHtml(article), nil
 }
 
-// Transform takes an input Document and returns it as codex HTML.
-func (cdx *Codex) Transform(codoc *Document) (string, error) {
-	codoc.CheckMtime()
-	codoc.SetBtime()
+// Transform takes an input Document and returns it as codex HTML, along with
+// its front matter, if any, and the assets it references, see LinkAssets().
+func (cdx *Codex) Transform(codoc *Document) (string, Metadata, map[string]bool, error) {
+	htmlDoc, meta, err := cdx.Render(codoc.Path)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	assets := LinkAssets(htmlDoc, codoc.Path)
+	return InnerHtml(htmlDoc.Find("body")), meta, assets, nil
+}
 
-	htmlDoc, err := cdx.pandocPool.Run(codoc.Path)
+// ReadAsset returns the contents of an asset referenced by the current build
+// of the given input, see ReadAsset(). Other files, even next to the input,
+// are not served.
+func (cdx *Codex) ReadAsset(source string, name string) ([]byte, error) {
+	cdx.mu.RLock()
+	codoc, ok := cdx.Inputs[source]
+	referenced := cdx.Assets[source][AssetName(name)]
+	cdx.mu.RUnlock()
+	if !ok || !referenced {
+		return nil, errors.New(fmt.Sprintf("Unknown asset %s of %s", name, source))
+	}
+	return ReadAsset(codoc.Path, name)
+}
+
+// Render runs the file at the given path through the full parse and
+// transform pipeline and returns the resulting treeified DOM and front
+// matter. Unlike Transform, it does not need the file to be one of the Codex
+// inputs, eg it is used to render past revisions of inputs.
+func (cdx *Codex) Render(path string) (*goquery.Document, Metadata, error) {
+	htmlDoc, meta, source, err := cdx.Load(path)
 	if err != nil {
+		return nil, nil, err
+	}
+	// inputs are untrusted, everything after this point is codex's own
+	cdx.sanitizer.Sanitize(htmlDoc)
+	rules := cdx.Config.headRules(path)
+	Normalize(htmlDoc, rules)
+	Treeify(htmlDoc, rules)
+	AnnotateSourceLines(htmlDoc, source)
+	cdx.tagger.Tag(htmlDoc)
+	MarkTasks(htmlDoc)
+	if cdx.Config.Journal {
+		AnnotateDates(htmlDoc, cdx.Config.dateFormats())
+	}
+	return htmlDoc, meta, nil
+}
+
+// TempSource writes contents to a new temporary file with the given
+// extension, eg ".md", and returns its path. It's the responsibility of the
+// caller to remove the file.
+func TempSource(ext string, contents []byte) (string, error) {
+	// pandoc infers input format from extension, hence the pattern
+	tmpfile, err := ioutil.TempFile("", "codex-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmpfile.Write(contents); err != nil {
+		tmpfile.Close()
 		return "", err
 	}
-	Treeify(htmlDoc)
-	return InnerHtml(htmlDoc.Find("body")), nil
+	if err := tmpfile.Close(); err != nil {
+		return "", err
+	}
+	return tmpfile.Name(), nil
 }
 
 func (cdx *Codex) BuildAll() error {
@@ -128,10 +295,180 @@ func (cdx *Codex) BuildAll() error {
 		return err
 	}
 
+	cdx.mu.Lock()
+	defer cdx.mu.Unlock()
 	cdx.HtmlStr = DocToHtml(cdx.HtmlDoc)
 	return nil
 }
 
+// TagIndex returns the nodes each inline tag occurs in across all inputs.
+func (cdx *Codex) TagIndex() map[string][]TagRef {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	index := make(map[string][]TagRef)
+	for _, tags := range cdx.Tags {
+		for tag, refs := range tags {
+			index[tag] = append(index[tag], refs...)
+		}
+	}
+	return index
+}
+
+// TaskList returns the tasks across all inputs, most recent first, see
+// SortTasks(). Done tasks are only included if all is true.
+func (cdx *Codex) TaskList(all bool) []Task {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	tasks := []Task{}
+	cdx.HtmlDoc.Find("article[codex-source]").Each(func(i int, article *goquery.Selection) {
+		for _, task := range cdx.Tasks[article.AttrOr("codex-source", "")] {
+			if all || !task.Done {
+				tasks = append(tasks, task)
+			}
+		}
+	})
+	SortTasks(tasks)
+	return tasks
+}
+
+// Timeline returns the journal entries across all inputs, most recent first,
+// optionally limited to the inclusive range of dates from-to, eg "2021-11-01"
+// to "2021-11-30". Empty from or to means unbounded.
+func (cdx *Codex) Timeline(from string, to string) []TimelineEntry {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+	return cdx.timeline(from, to)
+}
+
+// TimelineArticle returns the journal entries across all inputs, see
+// Timeline(), as a single <article> for the merged timeline view. Entries are
+// copies of their nodes, wherever they are in their inputs, each in a section
+// naming its input:
+//
+//	<article class="codex-timeline">
+//	  <section class="timeline-entry" codex-source="notes.md" codex-path="/home/me/notes.md">
+//	    <div class="node" codex-date="2021-11-30" ...> ... </div>
+//	  </section>
+//	  ...
+//	</article>
+func (cdx *Codex) TimelineArticle(from string, to string) string {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	doc, _ := LoadHtml(`<article class="codex-timeline"></article>`)
+	timeline := doc.Find("article")
+	for _, entry := range cdx.timeline(from, to) {
+		article := cdx.HtmlDoc.Find(fmt.Sprintf(`article[codex-source="%s"]`, entry.Source))
+		node := article.Find(fmt.Sprintf(`.node[id="%s"]`, entry.Id)).First()
+		if node.Length() == 0 {
+			continue
+		}
+		timeline.AppendHtml(`<section class="timeline-entry"></section>`)
+		section := timeline.Children().Last()
+		section.SetAttr("codex-source", entry.Source)
+		section.SetAttr("codex-path", article.AttrOr("codex-path", ""))
+		section.AppendSelection(node.Clone())
+	}
+	return OuterHtml(timeline)
+}
+
+// timeline is Timeline() for callers holding the read lock.
+func (cdx *Codex) timeline(from string, to string) []TimelineEntry {
+	entries := []TimelineEntry{}
+	cdx.HtmlDoc.Find("article[codex-source]").Each(func(i int, article *goquery.Selection) {
+		for _, entry := range cdx.Entries[article.AttrOr("codex-source", "")] {
+			if (from == "" || entry.Date >= from) && (to == "" || entry.Date <= to) {
+				entries = append(entries, entry)
+			}
+		}
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date > entries[j].Date
+	})
+	return entries
+}
+
 func (cdx *Codex) Html() string {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
 	return cdx.HtmlStr
 }
+
+// ArticleVersion identifies the current build of an input, see Articles().
+type ArticleVersion struct {
+	Source string `json:"source"`
+	Mtime  string `json:"mtime"` // the codex-mtime of its <article>
+}
+
+// BuildMtime returns the codex-mtime of the current build of an input, ie the
+// mtime of the input as of that build.
+func (cdx *Codex) BuildMtime(codoc *Document) string {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+	return cdx.CurrentDOMArticle(codoc).AttrOr("codex-mtime", "")
+}
+
+// Articles returns the versions of all articles in the current DOM, such that
+// clients can tell which of theirs are stale, see ArticleHtml().
+func (cdx *Codex) Articles() []ArticleVersion {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	versions := []ArticleVersion{}
+	cdx.HtmlDoc.Find("article[codex-source]").Each(func(i int, article *goquery.Selection) {
+		versions = append(versions, ArticleVersion{
+			Source: article.AttrOr("codex-source", ""),
+			Mtime:  article.AttrOr("codex-mtime", ""),
+		})
+	})
+	return versions
+}
+
+// ArticleHtml returns the current <article> of the given input.
+func (cdx *Codex) ArticleHtml(source string) (string, error) {
+	codoc, ok := cdx.Inputs[source]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("No such input: %s", source))
+	}
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+	return OuterHtml(cdx.CurrentDOMArticle(codoc)), nil
+}
+
+// NodeRef is a detached copy of a node in the current DOM along with the
+// context it was found in.
+type NodeRef struct {
+	Node   *goquery.Selection // a clone, safe to use without holding locks
+	Source *Document
+	// Mtime is the codex-mtime of the node's article, ie the mtime of its
+	// source as of the build the node is from.
+	Mtime string
+
+	// HeadPath is the text of the node's head, preceded by those of all its
+	// ancestors, eg ["2021-11-30 Tue", "Meeting notes", "Action items"].
+	HeadPath []string
+}
+
+// LookupNode finds the node with the given id in the current DOM.
+func (cdx *Codex) LookupNode(id string) (*NodeRef, error) {
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	node := cdx.HtmlDoc.Find(fmt.Sprintf(`.node[id="%s"]`, id)).First()
+	if node.Length() == 0 {
+		return nil, errors.New(fmt.Sprintf("No such node: %s", id))
+	}
+	article := node.Closest("article[codex-source]")
+	codoc, ok := cdx.Inputs[article.AttrOr("codex-source", "")]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Node %s has no source", id))
+	}
+	return &NodeRef{
+		Node:     node.Clone(),
+		Source:   codoc,
+		Mtime:    article.AttrOr("codex-mtime", ""),
+		HeadPath: HeadPath(node),
+	}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Articles(t *testing.T) {
+	dir := t.TempDir()
+	cdx := fixtureCodex(dir, Config{}, fixtureInput{Name: "a.html", Html: fixtureNotesHtml})
+	source := filepath.Join(dir, "a.html")
+	cdx.HtmlDoc.Find("article").SetAttr("codex-mtime", "2021-11-30T10:00:00Z")
+
+	assert.Equal(t, []ArticleVersion{{Source: source, Mtime: "2021-11-30T10:00:00Z"}}, cdx.Articles())
+
+	article, err := cdx.ArticleHtml(source)
+	assert.Nil(t, err)
+	doc, _ := LoadHtml(article)
+	assert.Equal(t, source, doc.Find("article").AttrOr("codex-source", ""))
+	assert.Equal(t, 2, selCount(doc.Selection, "article > .node"))
+
+	_, err = cdx.ArticleHtml("missing.md")
+	assert.NotNil(t, err)
+}
+
+func Test_TimelineArticle(t *testing.T) {
+	dir := t.TempDir()
+	journal := filepath.Join(dir, "journal.html")
+	other := filepath.Join(dir, "other.html")
+	cdx := fixtureCodex(dir, Config{Journal: true},
+		fixtureInput{Name: "journal.html", Html: `
+			<h1>Journal</h1>
+			<h2>2021-11-30</h2> <p>first</p>
+			<h2>2021-12-02</h2> <p>third</p>
+		`},
+		fixtureInput{Name: "other.html", Html: `<h1>2021-12-01</h1> <p>second</p>`},
+	)
+
+	// entries nested in undated nodes are merged too
+	doc, _ := LoadHtml(cdx.TimelineArticle("", ""))
+	sections := doc.Find("article.codex-timeline > section.timeline-entry")
+	assert.Equal(t, []string{"2021-12-02", "2021-12-01", "2021-11-30"},
+		sections.Map(func(i int, sec *goquery.Selection) string {
+			return sec.Children().AttrOr("codex-date", "")
+		}))
+	assert.Equal(t, []string{journal, other, journal},
+		sections.Map(func(i int, sec *goquery.Selection) string {
+			return sec.AttrOr("codex-source", "")
+		}))
+	assert.Equal(t, other, sections.Eq(1).AttrOr("codex-path", ""))
+	assert.Equal(t, "third", selText(sections.Eq(0).Find("p")))
+	assert.NotContains(t, selText(doc.Find(".node-head")), "Journal")
+
+	doc, _ = LoadHtml(cdx.TimelineArticle("2021-12-01", "2021-12-02"))
+	assert.Equal(t, 2, selCount(doc.Selection, "section.timeline-entry"))
+}
+
+func Test_ReadAsset(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "a.html")
+	ioutil.WriteFile(filepath.Join(dir, "fig.png"), []byte("PNG"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	cdx := fixtureCodex(dir, Config{}, fixtureInput{Name: "a.html", Html: fixtureNotesHtml})
+	col := &Collection{Codex: cdx}
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		col.handleAsset(rec, httptest.NewRequest("GET", "/api/asset?"+query.Encode(), nil))
+		return rec
+	}
+
+	contents, err := cdx.ReadAsset(source, "./images/../fig.png")
+	assert.Nil(t, err)
+	assert.Equal(t, "PNG", string(contents))
+	rec := get(url.Values{"source": {source}, "path": {"fig.png"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "PNG", rec.Body.String())
+
+	// only assets referenced by the input are served
+	for _, name := range []string{"secret.txt", "a.html", "../a.html", ""} {
+		_, err = cdx.ReadAsset(source, name)
+		assert.NotNil(t, err, name)
+		assert.Equal(t, http.StatusNotFound, get(url.Values{"source": {source}, "path": {name}}).Code, name)
+	}
+	assert.Equal(t, http.StatusNotFound, get(url.Values{"source": {"other.md"}, "path": {"fig.png"}}).Code)
+}
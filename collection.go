@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/fsnotify/fsnotify"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// collectionNameRegex is what collection names, and hence their URL path
+// prefixes, are made of.
+var collectionNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// bootId identifies this codex process. Pages carry it, see DOMSkeleton(), and
+// clients send it back when they reconnect, such that pages served by a
+// previous process, eg before a restart, are told to reload.
+var bootId = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// Collection is a single Codex served by a Server, along with everything it
+// takes to keep its clients up to date: its own file watcher, build queue,
+// and websockets. Collections are independent of each other.
+type Collection struct {
+	Name  string // empty for the only collection of a server, see Server
+	Codex *Codex
+
+	watcher *fsnotify.Watcher
+
+	updates chan *Document
+	builds  chan *Document
+
+	// wsMu guards websockets, which are added by HTTP handlers and dropped by
+	// the build loop, and seq, see Message.
+	wsMu       sync.Mutex
+	websockets []*wsClient
+	seq        uint64
+}
+
+func NewCollection(name string, paths []string, conf Config) (*Collection, error) {
+	cdx, err := NewCodex(paths, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := createWatcher(cdx.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collection{
+		Name:    name,
+		Codex:   cdx,
+		watcher: watcher,
+		updates: make(chan *Document),
+		builds:  make(chan *Document),
+	}, nil
+}
+
+func createWatcher(codocs map[string]*Document) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, codoc := range codocs {
+		if err := watcher.Add(codoc.Path); err != nil {
+			return nil, err
+		}
+	}
+	return watcher, nil
+}
+
+// Prefix is the URL path the collection is served under, eg "/c/runbooks".
+func (col *Collection) Prefix() string {
+	if col.Name == "" {
+		return ""
+	}
+	return "/c/" + col.Name
+}
+
+func (col *Collection) Watch() {
+	log.Println("Watching", len(col.Codex.Inputs), "docs for changes ...")
+	for {
+		select {
+		case event, ok := <-col.watcher.Events:
+			if !ok {
+				log.Fatal("filesystem watcher crash!")
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				col.updates <- col.Codex.Inputs[event.Name]
+			}
+		case err, ok := <-col.watcher.Errors:
+			if !ok {
+				log.Fatal("filesystem watcher crash!")
+			}
+			log.Println("watch error:", err)
+		}
+	}
+}
+
+func (col *Collection) UpdateOnChange() {
+	for {
+		select {
+		case codoc := <-col.updates:
+			time.AfterFunc(debounceWait, func() {
+				col.builds <- codoc
+			})
+		case codoc := <-col.builds:
+			if codoc.CheckMtime().After(codoc.Btime) {
+				log.Println("building:", codoc.Path)
+				col.UpdateClients(Message{Type: MsgBuildStarted, Source: codoc.Path})
+				htmlStr, err := col.Codex.Update(codoc)
+				if err != nil {
+					// keep serving the last good build, see /api/status
+					log.Println("build failed:", codoc.Path, err)
+					col.UpdateClients(Message{
+						Type:    MsgBuildFailed,
+						Source:  codoc.Path,
+						Payload: ErrorPayload{Error: err.Error()},
+					})
+					continue
+				}
+				col.UpdateClients(Message{
+					Type:    MsgBuildFinished,
+					Source:  codoc.Path,
+					Payload: ArticlePayload{Html: htmlStr},
+				})
+			}
+		}
+	}
+}
+
+// UpdateClients sends a message to all websockets, numbered after the last
+// one, see Message.
+func (col *Collection) UpdateClients(msg Message) {
+	col.wsMu.Lock()
+	defer col.wsMu.Unlock()
+	col.seq++
+	msg.Version, msg.Seq = ProtocolVersion, col.seq
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Failed to encode message,", err)
+		return
+	}
+	log.Println("Updating", len(col.websockets), "websocket(s):", msg.Type)
+	// note: iterating backwards since dropping moves the last one into idx
+	for idx := len(col.websockets) - 1; idx >= 0; idx-- {
+		if err := col.websockets[idx].send(msg, encoded); err != nil {
+			log.Println("Failed to write to websocket,", err)
+			col.dropWebSocket(idx)
+		}
+	}
+}
+
+// WebSocketCount returns the number of connected websockets.
+func (col *Collection) WebSocketCount() int {
+	col.wsMu.Lock()
+	defer col.wsMu.Unlock()
+	return len(col.websockets)
+}
+
+func (col *Collection) dropWebSocket(idx int) {
+	log.Println("Dropping stale websocket:", col.websockets[idx].conn.RemoteAddr())
+	nsocks := len(col.websockets)
+	col.websockets[idx] = col.websockets[nsocks-1]
+	col.websockets = col.websockets[:nsocks-1]
+}
+
+// Handler returns the HTTP handler of the collection, with paths relative to
+// its prefix, eg "/api/search".
+func (col *Collection) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/static/", col.Codex.Config.theme().handleStatic)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+		io.WriteString(w, col.Codex.Html())
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // TODO when does this happen?
+		}
+		client := newWsClient(ws)
+		log.Println("Accepted new websocket from", r.RemoteAddr, "legacy:", client.legacy)
+		if client.legacy {
+			col.wsMu.Lock()
+			col.websockets = append(col.websockets, client)
+			col.wsMu.Unlock()
+			return
+		}
+
+		col.wsMu.Lock()
+		defer col.wsMu.Unlock()
+		// note: under wsMu such that no message goes out between hello and
+		// the client joining websockets, ie seq has no gaps.
+		hello := Message{Version: ProtocolVersion, Type: MsgHello, Seq: col.seq}
+		if err := client.sendJSON(hello); err != nil {
+			log.Println("Failed to write to websocket,", err)
+			ws.Close()
+			return
+		}
+		if boot := r.URL.Query().Get("boot"); boot != "" && boot != bootId {
+			if err := client.sendJSON(Message{Version: ProtocolVersion, Type: MsgReload, Seq: col.seq}); err != nil {
+				log.Println("Failed to write to websocket,", err)
+				ws.Close()
+				return
+			}
+		}
+		col.websockets = append(col.websockets, client)
+	})
+	mux.HandleFunc("/api/articles", col.handleArticles)
+	mux.HandleFunc("/api/article", col.handleArticle)
+	mux.HandleFunc("/api/history", col.handleHistory)
+	mux.HandleFunc("/api/revision", col.handleRevision)
+	mux.HandleFunc("/api/node", col.handleNode)
+	mux.HandleFunc("/api/source", col.handleSource)
+	mux.HandleFunc("/api/search", col.handleSearch)
+	mux.HandleFunc("/api/tags", col.handleTags)
+	mux.HandleFunc("/api/tasks", col.handleTasks)
+	mux.HandleFunc("/api/timeline", col.handleTimeline)
+	mux.HandleFunc("/api/asset", col.handleAsset)
+	mux.HandleFunc("/api/tree", col.handleTree)
+	mux.HandleFunc("/api/status", col.handleStatus)
+	mux.HandleFunc("/export", col.handleExport)
+	return mux
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the user-configurable behavior of a Codex instance. It is
+// populated from CLI flags, see cli.go.
+type Config struct {
+	// History enables reading the git history of inputs, see history.go.
+	History bool
+
+	// Edit enables editing node sources from the browser, see edit.go.
+	Edit bool
+
+	// EditorUrl is a template for links that open nodes in an editor, eg
+	// "vscode://file/{path}:{line}". Empty means no editor links.
+	EditorUrl string
+
+	// Journal enables journal mode, where nodes headed by dates form a single
+	// timeline across all inputs, see dates.go.
+	Journal bool
+
+	// DateFormats are the layouts of dates in node heads, in Go's time.Parse
+	// syntax. Empty means DefaultDateFormats.
+	DateFormats []string
+
+	// HeadRules overrides DefaultHeadRules per file extension, eg ".org". The
+	// special key "*" applies to all other extensions, see LoadHeadRules().
+	HeadRules map[string]HeadRules
+
+	// TagPatterns are the regular expressions for inline tags, see tags.go.
+	TagPatterns []string
+
+	// Theme is the look of pages, see theme.go. Nil means DefaultTheme.
+	Theme *Theme
+
+	// Sanitize is the name of the HTML sanitization policy for inputs, see
+	// SanitizePolicies. Empty means strict.
+	Sanitize string
+}
+
+// dateFormats returns the configured DateFormats or the default ones.
+func (conf Config) dateFormats() []string {
+	if len(conf.DateFormats) == 0 {
+		return DefaultDateFormats
+	}
+	return conf.DateFormats
+}
+
+// theme returns the configured Theme or the default one.
+func (conf Config) theme() *Theme {
+	if conf.Theme == nil {
+		return DefaultTheme
+	}
+	return conf.Theme
+}
+
+// headRules returns the HeadRules that apply to the file at path.
+func (conf Config) headRules(path string) HeadRules {
+	if rules, ok := conf.HeadRules[strings.ToLower(filepath.Ext(path))]; ok {
+		return rules
+	}
+	if rules, ok := conf.HeadRules["*"]; ok {
+		return rules
+	}
+	return DefaultHeadRules
+}
+
+// LoadHeadRules reads per file extension HeadRules from a YAML file, eg:
+//    .org:
+//      - {selector: h1, rank: 0}
+//      - {selector: h2, rank: 1}
+//      - {selector: dt, rank: 2, unwrap: dl}
+//    .docx:
+//      - {selector: 'div[custom-style="Title"]', rank: 0}
+//      - {selector: p, match: bold-only, rank: 1}
+func LoadHeadRules(path string) (map[string]HeadRules, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var byExt map[string]HeadRules
+	if err := yaml.Unmarshal(contents, &byExt); err != nil {
+		return nil, err
+	}
+	rulesByExt := make(map[string]HeadRules)
+	for ext, rules := range byExt {
+		if err := rules.Validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+		}
+		if ext != "*" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		rulesByExt[strings.ToLower(ext)] = rules
+	}
+	return rulesByExt, nil
+}
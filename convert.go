@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"os/exec"
+	"strings"
+)
+
+// NodeFormats maps formats nodes can be converted to, see ConvertNode(), to
+// their MIME types.
+var NodeFormats = map[string]string{
+	"markdown": "text/markdown; charset=utf-8",
+	"plain":    "text/plain; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+}
+
+// allowedAttrs are the only attributes that survive ConvertNode(sel, "html"),
+// after sanitization, see StrictPolicy.
+var allowedAttrs = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true,
+	"colspan": true, "rowspan": true, "start": true,
+}
+
+// PandocConvert runs pandoc as a filter and converts the given contents from
+// one pandoc format to another, with any further pandoc options.
+func PandocConvert(contents string, from string, to string, options ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("pandoc", append([]string{"--from", from, "--to", to}, options...)...)
+	cmd.Stdin = strings.NewReader(contents)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(fmt.Sprintf(
+			"pandoc failed: %s %s", err, strings.TrimSpace(stderr.String()),
+		))
+	}
+	return stdout.String(), nil
+}
+
+// Untreeify undoes what Treeify does to a node subtree and returns plain
+// document HTML, as if the subtree were a standalone document. The given
+// selection is not modified.
+func Untreeify(node *goquery.Selection) *goquery.Selection {
+	sel := node.Clone()
+	sel.Find(".headless > .node-head").Remove()
+	// all divs, not just codex's own: pandoc writes divs as raw HTML for
+	// formats that have no notion of them, eg markdown.
+	Unwrap(sel.Find("div, span.node-head"))
+	sel.Find("li.node").AddSelection(sel.Filter("li.node")).
+		RemoveAttr("class").RemoveAttr("id")
+	if sel.Is("div") {
+		return sel.Contents()
+	}
+	return sel
+}
+
+// ConvertNode converts a node subtree to one of the NodeFormats. HTML is
+// sanitized with the StrictPolicy, and stripped of codex's own attributes.
+func (cdx *Codex) ConvertNode(node *goquery.Selection, format string) (string, error) {
+	if _, ok := NodeFormats[format]; !ok {
+		return "", errors.New(fmt.Sprintf("Unknown format: %s", format))
+	}
+	wrapper, err := LoadHtml("<div></div>")
+	if err != nil {
+		return "", err
+	}
+	body := wrapper.Find("div")
+	if node.Is("li") {
+		// a list item on its own is not valid HTML, keep its list
+		body.AppendHtml("<ul></ul>")
+		body.Children().AppendSelection(Untreeify(node))
+	} else {
+		body.AppendSelection(Untreeify(node))
+	}
+
+	switch format {
+	case "markdown":
+		return cdx.pandocConvert(InnerHtml(body), "html", "gfm")
+	case "plain":
+		return cdx.pandocConvert(InnerHtml(body), "html", "plain")
+	}
+
+	StrictPolicy.Sanitize(wrapper)
+	body.Find("*").Each(func(i int, elem *goquery.Selection) {
+		var kept []html.Attribute
+		for _, attr := range elem.Get(0).Attr {
+			if allowedAttrs[attr.Key] {
+				kept = append(kept, attr)
+			}
+		}
+		elem.Get(0).Attr = kept
+	})
+	return InnerHtml(body), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_ConvertNode_html(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1 id="title" class="unnumbered">Title</h1>
+		<p onclick="alert(1)">Hello <a href="javascript:alert(1)">World</a></p>
+		<p><a href="JavaScript:alert(1)">Upper</a> <a href="java	script:alert(1)">Tab</a></p>
+		<ul><li>Li1</li></ul>
+	`)
+	Treeify(doc, DefaultHeadRules)
+
+	cdx := &Codex{}
+	converted, err := cdx.ConvertNode(doc.Find(".node-depth-0"), "html")
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(converted, "node"))
+	assert.False(t, strings.Contains(converted, "div"))
+	assert.False(t, strings.Contains(converted, "alert"))
+	assert.True(t, strings.Contains(converted, "<h1>Title</h1>"))
+	assert.True(t, strings.Contains(converted, "<li>Li1</li>"))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"time"
+)
+
+// DefaultDateFormats are the layouts, in Go's time.Parse syntax, of dates
+// recognized at the start of node heads, eg "2021-11-30 Tue".
+var DefaultDateFormats = []string{"2006-01-02"}
+
+// ParseHeadDate parses a date at the start of a head text in any of the
+// given formats, the rest of the text is ignored.
+func ParseHeadDate(text string, formats []string) (time.Time, bool) {
+	words := strings.Fields(text)
+	for _, format := range formats {
+		// formats may have spaces, eg "Jan 2, 2006", try all prefixes
+		for n := 1; n <= len(words) && n <= len(strings.Fields(format)); n++ {
+			prefix := strings.TrimRight(strings.Join(words[:n], " "), ",:;.")
+			if date, err := time.Parse(format, prefix); err == nil {
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// HeadPathDate returns the first date found in a HeadPath, outermost first.
+func HeadPathDate(path []string, formats []string) (time.Time, bool) {
+	for _, head := range path {
+		if date, ok := ParseHeadDate(head, formats); ok {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// TimelineEntry is a top-level dated node in journal mode, see AnnotateDates().
+type TimelineEntry struct {
+	Date   string `json:"date"` // eg 2021-11-30
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Head   string `json:"head"`
+}
+
+// AnnotateDates marks nodes whose heads start with a date, in any of the
+// given formats, with a codex-date attribute, eg codex-date="2021-11-30".
+// Only the outermost dated nodes are marked, they are the journal entries.
+func AnnotateDates(doc *goquery.Document, formats []string) {
+	doc.Find("div.node:not(.headless)").Each(func(i int, node *goquery.Selection) {
+		if node.ParentsFiltered("[codex-date]").Length() > 0 {
+			return
+		}
+		if date, ok := ParseHeadDate(HeadText(node), formats); ok {
+			node.SetAttr("codex-date", date.Format("2006-01-02"))
+		}
+	})
+}
+
+// Timeline returns the entries marked by AnnotateDates in an <article>.
+func Timeline(article *goquery.Selection) []TimelineEntry {
+	source := article.AttrOr("codex-source", "")
+	entries := []TimelineEntry{}
+	article.Find(".node[codex-date]").Each(func(i int, node *goquery.Selection) {
+		entries = append(entries, TimelineEntry{
+			Date:   node.AttrOr("codex-date", ""),
+			Id:     node.AttrOr("id", ""),
+			Source: source,
+			Head:   HeadText(node),
+		})
+	})
+	return entries
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ParseHeadDate(t *testing.T) {
+	formats := []string{"2006-01-02", "Jan 2, 2006"}
+	date, ok := ParseHeadDate("2021-11-30 Tue", formats)
+	assert.True(t, ok)
+	assert.Equal(t, "2021-11-30", date.Format("2006-01-02"))
+
+	date, ok = ParseHeadDate("Nov 30, 2021: retro", formats)
+	assert.True(t, ok)
+	assert.Equal(t, "2021-11-30", date.Format("2006-01-02"))
+
+	_, ok = ParseHeadDate("Meeting on 2021-11-30", formats)
+	assert.False(t, ok)
+}
+
+func Test_AnnotateDates(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1>2021-11-30 Tue</h1>
+		<h2>2021-11-29 is mentioned here</h2>
+		<h1>Ideas</h1>
+		<h1>2021-12-01</h1>
+	`)
+	Treeify(doc, DefaultHeadRules)
+	AnnotateDates(doc, DefaultDateFormats)
+
+	doc.Find("body").SetAttr("codex-source", "journal.md")
+	entries := Timeline(doc.Find("body"))
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "2021-11-30", entries[0].Date)
+	assert.Equal(t, "2021-11-30 Tue", entries[0].Head)
+	assert.Equal(t, "2021-12-01", entries[1].Date)
+}
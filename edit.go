@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ErrConflict is returned when an edit is based on a stale build of its source,
+// or the lines it replaces are not the ones it was based on.
+var ErrConflict = errors.New("source has changed since it was last built")
+
+// SourceEdit replaces a range of lines in an input document, see PatchSource().
+type SourceEdit struct {
+	Source string `json:"source"`
+	Start  int    `json:"start"` // 1-based, inclusive
+	End    int    `json:"end"`   // 1-based, inclusive
+	Text   string `json:"text"`
+
+	// Mtime is the codex-mtime of the build the edit is based on.
+	Mtime string `json:"mtime"`
+	// Hash is the SourceHash() of the lines the edit replaces, as they were
+	// served, clients send it back as is.
+	Hash string `json:"hash"`
+}
+
+// SourceHash returns the hex encoded SHA-256 of a span of source lines.
+func SourceHash(span string) string {
+	sum := sha256.Sum256([]byte(span))
+	return hex.EncodeToString(sum[:])
+}
+
+// PatchSource writes an edit back to its source file. Edits are only applied
+// if the source has not changed since the current build, which the edit must
+// be based on, and the lines it replaces are still the ones it was based on,
+// otherwise ErrConflict is returned. The file change then triggers the usual
+// rebuild.
+func (cdx *Codex) PatchSource(edit SourceEdit) error {
+	cdx.editMu.Lock()
+	defer cdx.editMu.Unlock()
+
+	codoc, ok := cdx.Inputs[edit.Source]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unexpected input doc: %s", edit.Source))
+	}
+	if edit.Hash == "" {
+		return errors.New(fmt.Sprintf("Missing hash of lines %d-%d", edit.Start, edit.End))
+	}
+	info, err := os.Stat(codoc.Path)
+	if err != nil {
+		return err
+	}
+	// note: the file's own mtime too, it may have changed since the build and
+	// not have been rebuilt yet.
+	mtime := cdx.BuildMtime(codoc)
+	if edit.Mtime != mtime || ToIso8601(info.ModTime()) != mtime {
+		return ErrConflict
+	}
+
+	contents, err := ioutil.ReadFile(codoc.Path)
+	if err != nil {
+		return err
+	}
+	span, ok := sourceLines(string(contents), edit.Start, edit.End)
+	if !ok {
+		return errors.New(fmt.Sprintf(
+			"Invalid line range %d-%d for %s", edit.Start, edit.End, edit.Source,
+		))
+	}
+	// mtimes are only as precise as ToIso8601(), the lines themselves must be
+	// the ones the edit was made against too
+	if SourceHash(span) != edit.Hash {
+		return ErrConflict
+	}
+	lines := strings.Split(string(contents), "\n")
+
+	var patched []string
+	patched = append(patched, lines[:edit.Start-1]...)
+	patched = append(patched, strings.Split(strings.TrimSuffix(edit.Text, "\n"), "\n")...)
+	patched = append(patched, lines[edit.End:]...)
+
+	// note: writing in place, as opposed to write-and-rename, keeps the file
+	// watcher attached to the file.
+	return ioutil.WriteFile(codoc.Path, []byte(strings.Join(patched, "\n")), info.Mode())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const editSource = "one\ntwo\nthree\nfour\n"
+
+// editCodex returns a codex with editing enabled and a single input, and the
+// mtime of its build as edits expect it.
+func editCodex(t *testing.T, source string) (*Codex, string, string) {
+	dir := t.TempDir()
+	cdx := fixtureCodex(dir, Config{Edit: true}, fixtureInput{Name: "notes.html", Html: source})
+	path := filepath.Join(dir, "notes.html")
+	return cdx, path, cdx.BuildMtime(cdx.Inputs[path])
+}
+
+func readSource(path string) string {
+	contents, _ := ioutil.ReadFile(path)
+	return string(contents)
+}
+
+// spanHash returns the SourceHash() of lines of a file, as they are now.
+func spanHash(path string, start int, end int) string {
+	span, _ := sourceLines(readSource(path), start, end)
+	return SourceHash(span)
+}
+
+func Test_PatchSource(t *testing.T) {
+	cdx, path, mtime := editCodex(t, editSource)
+
+	err := cdx.PatchSource(SourceEdit{
+		Source: path, Start: 2, End: 3, Text: "TWO\nTHREE\nand a half\n", Mtime: mtime, Hash: spanHash(path, 2, 3),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "one\nTWO\nTHREE\nand a half\nfour\n", readSource(path))
+
+	// a single line, without a trailing newline in the text
+	cdx, path, mtime = editCodex(t, editSource)
+	err = cdx.PatchSource(SourceEdit{Source: path, Start: 4, End: 4, Text: "FOUR", Mtime: mtime, Hash: spanHash(path, 4, 4)})
+	assert.Nil(t, err)
+	assert.Equal(t, "one\ntwo\nthree\nFOUR\n", readSource(path))
+}
+
+func Test_PatchSource_invalid(t *testing.T) {
+	cdx, path, mtime := editCodex(t, editSource)
+	for _, lines := range [][]int{{0, 1}, {3, 2}, {2, 7}, {-1, -1}} {
+		err := cdx.PatchSource(SourceEdit{
+			Source: path, Start: lines[0], End: lines[1], Text: "x", Mtime: mtime, Hash: spanHash(path, 1, 1),
+		})
+		assert.NotNil(t, err, lines)
+		assert.NotEqual(t, ErrConflict, err, lines)
+	}
+
+	err := cdx.PatchSource(SourceEdit{Source: "other.md", Start: 1, End: 1, Mtime: mtime, Hash: spanHash(path, 1, 1)})
+	assert.NotNil(t, err)
+	err = cdx.PatchSource(SourceEdit{Source: path, Start: 1, End: 1, Text: "x", Mtime: mtime})
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrConflict, err)
+
+	// based on a build from before the last change to the file
+	err = cdx.PatchSource(SourceEdit{
+		Source: path, Start: 1, End: 1, Text: "x", Mtime: "2001-01-01T00:00:00Z", Hash: spanHash(path, 1, 1),
+	})
+	assert.Equal(t, ErrConflict, err)
+
+	// against lines other than the ones there now
+	err = cdx.PatchSource(SourceEdit{
+		Source: path, Start: 1, End: 1, Text: "x", Mtime: mtime, Hash: spanHash(path, 2, 2),
+	})
+	assert.Equal(t, ErrConflict, err)
+
+	// the file changed since the build, and has not been rebuilt yet
+	later := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	err = cdx.PatchSource(SourceEdit{
+		Source: path, Start: 1, End: 1, Text: "x", Mtime: mtime, Hash: spanHash(path, 1, 1),
+	})
+	assert.Equal(t, ErrConflict, err)
+	assert.Equal(t, editSource, readSource(path))
+}
+
+func Test_handleSource(t *testing.T) {
+	cdx, path, mtime := editCodex(t, editSource)
+	col := &Collection{Codex: cdx}
+	post := func(body string, contentType string) int {
+		req := httptest.NewRequest("POST", "/api/source", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		col.handleSource(rec, req)
+		return rec.Code
+	}
+	hash := spanHash(path, 1, 1)
+	edit := `{"source": "` + path + `", "start": 1, "end": 1, "text": "ONE", "hash": "` + hash + `", "mtime": "%s"}`
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, post(strings.Replace(edit, "%s", mtime, 1), "text/plain"))
+	assert.Equal(t, http.StatusConflict, post(strings.Replace(edit, "%s", "2001-01-01T00:00:00Z", 1), "application/json"))
+	assert.Equal(t, http.StatusBadRequest, post(`{"source": "`+path+`", "start": 3, "end": 2, "hash": "`+hash+`", "mtime": "`+mtime+`"}`, "application/json"))
+	assert.Equal(t, editSource, readSource(path))
+
+	assert.Equal(t, http.StatusNoContent, post(strings.Replace(edit, "%s", mtime, 1), "application/json"))
+	assert.Equal(t, "ONE\ntwo\nthree\nfour\n", readSource(path))
+
+	cdx.Config.Edit = false
+	assert.Equal(t, http.StatusNotFound, post(strings.Replace(edit, "%s", mtime, 1), "application/json"))
+}
+
+func Test_PatchSource_rebuild(t *testing.T) {
+	cdx, path, _ := editCodex(t, "<h1>One</h1>\n<p>first</p>\n<h1>Two</h1>\n<p>second</p>\n")
+	col := &Collection{Codex: cdx}
+	codoc := cdx.Inputs[path]
+	// get and post as the browser does, see initEditing() in codex.js
+	getEdit := func(head string) (SourceEdit, int) {
+		var edit SourceEdit
+		id := nodeByHead(cdx.HtmlDoc, head).AttrOr("id", "")
+		rec := httptest.NewRecorder()
+		col.handleSource(rec, httptest.NewRequest("GET", "/api/source?node="+id, nil))
+		json.Unmarshal(rec.Body.Bytes(), &edit)
+		return edit, rec.Code
+	}
+	postEdit := func(edit SourceEdit) int {
+		body, _ := json.Marshal(edit)
+		req := httptest.NewRequest("POST", "/api/source", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		col.handleSource(rec, req)
+		return rec.Code
+	}
+	edit, code := getEdit("Two")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, edit.Start)
+
+	// lines are added above the node while it is being edited, and the file is
+	// rebuilt while the edit is posted: whichever happens first, the edit must
+	// not replace lines 3-4, which are no longer the node.
+	changed := "<h1>Zero</h1>\n<p>zeroth</p>\n" + readSource(path)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(changed), 0644))
+	later := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	done := make(chan bool)
+	go func() {
+		cdx.Update(codoc)
+		close(done)
+	}()
+	edit.Text = "<h1>Two</h1>\n<p>edited</p>\n"
+	assert.Equal(t, http.StatusConflict, postEdit(edit))
+	<-done
+	assert.Equal(t, http.StatusConflict, postEdit(edit))
+	assert.Equal(t, changed, readSource(path))
+
+	// the same change, but within the precision of mtimes
+	cdx.Update(codoc)
+	edit, _ = getEdit("Two")
+	mtime := cdx.BuildMtime(codoc)
+	info, _ := os.Stat(path)
+	changed = "<h1>Minus one</h1>\n" + readSource(path)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(changed), 0644))
+	assert.Nil(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	assert.Equal(t, mtime, ToIso8601(info.ModTime()))
+	edit.Text = "<h1>Two</h1>\n<p>edited</p>\n"
+	assert.Equal(t, http.StatusConflict, postEdit(edit))
+	assert.Equal(t, changed, readSource(path))
+
+	// edits of a fresh build go through while builds go on
+	cdx.Update(codoc)
+	edit, code = getEdit("Two")
+	assert.Equal(t, http.StatusOK, code)
+	done = make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			cdx.Update(codoc)
+		}
+		close(done)
+	}()
+	edit.Text = "<h1>Two</h1>\n<p>edited</p>\n"
+	assert.Equal(t, http.StatusNoContent, postEdit(edit))
+	<-done
+	assert.Equal(t, strings.Replace(changed, "second", "edited", 1), readSource(path))
+}
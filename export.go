@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ExportFormat is a format of exports, see Codex.Export().
+type ExportFormat struct {
+	ContentType string
+	Ext         string // of exported files, eg ".md"
+	// Writer is the pandoc output format, empty for html and json, which
+	// codex writes itself, see PrintHtml() and Tree().
+	Writer string
+}
+
+// ExportFormats are the formats of exports by name.
+var ExportFormats = map[string]ExportFormat{
+	"html":     {ContentType: "text/html; charset=utf-8", Ext: ".html"},
+	"json":     {ContentType: "application/json", Ext: ".json"},
+	"pdf":      {ContentType: "application/pdf", Ext: ".pdf", Writer: "latex"},
+	"markdown": {ContentType: "text/markdown; charset=utf-8", Ext: ".md", Writer: "gfm"},
+	"org":      {ContentType: "text/x-org; charset=utf-8", Ext: ".org", Writer: "org"},
+	"docx": {
+		ContentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		Ext:         ".docx",
+		Writer:      "docx",
+	},
+}
+
+// LatexEngines are the PDF engines pandoc can use that codex looks for, in
+// order of preference.
+var LatexEngines = []string{"xelatex", "lualatex", "pdflatex", "tectonic"}
+
+// ExportScope is the part of a codex to export. The zero scope is the whole
+// codex, otherwise it's either a single input or a single node subtree.
+type ExportScope struct {
+	Source string // path of an input
+	Node   string // id of a node, see LookupNode()
+}
+
+// Export returns the treeified contents in the given scope as one of the
+// ExportFormats:
+//  - html: a self-contained page for printing, with all folds expanded and
+//    a page break before every top-level node, see PrintHtml(),
+//  - json: the node tree, see Tree(),
+//  - anything else: a single document converted by pandoc, see
+//    ExportDocument().
+func (cdx *Codex) Export(scope ExportScope, name string) ([]byte, error) {
+	format, ok := ExportFormats[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown export format: %s", name))
+	}
+	switch name {
+	case "html":
+		page, err := cdx.PrintHtml(scope)
+		return []byte(page), err
+	case "json":
+		tree, err := cdx.Tree(scope)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(tree, "", "  ")
+	}
+	return cdx.ExportDocument(scope, format)
+}
+
+// PrintHtml returns the contents in the given scope as a standalone print
+// optimized page, see PrintOutputTemplate.
+func (cdx *Codex) PrintHtml(scope ExportScope) (string, error) {
+	content, title, err := cdx.exportSelection(scope)
+	if err != nil {
+		return "", err
+	}
+	doc, err := LoadHtml(PrintOutputTemplate)
+	if err != nil {
+		return "", err
+	}
+	doc.Find("title").SetText(title)
+	doc.Find("style").SetText(pandocCss + printCss)
+
+	main := doc.Find("main")
+	main.AppendSelection(content)
+	main.Find(".collapsed").RemoveClass("collapsed")
+	// the first top-level node needs no page break
+	if pages := main.Find("article > .node"); pages.Length() > 1 {
+		pages.Slice(1, pages.Length()).AddClass("codex-page-break")
+	}
+	cdx.inlineAssets(main)
+	return DocToHtml(doc), nil
+}
+
+// ExportDocument converts the contents in the given scope to a single
+// document with pandoc. Heads are re-leveled after the depths of their nodes,
+// see relevelHeads(), such that inputs merged into the codex end up with
+// consistent heading levels. PDFs need a LaTeX engine, see PdfEngine().
+func (cdx *Codex) ExportDocument(scope ExportScope, format ExportFormat) ([]byte, error) {
+	args := []string{"--from", "html", "--to", format.Writer, "--standalone"}
+	if format.Ext == ".pdf" {
+		engine, ok := PdfEngine()
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(
+				"PDF export needs a LaTeX engine, none of %s is installed",
+				strings.Join(LatexEngines, ", "),
+			))
+		}
+		args = append(args, "--pdf-engine", engine)
+	}
+	content, title, err := cdx.exportSelection(scope)
+	if err != nil {
+		return nil, err
+	}
+	relevelHeads(content)
+	wrapper, err := LoadHtml("<div></div>")
+	if err != nil {
+		return nil, err
+	}
+	body := wrapper.Find("div")
+	body.AppendSelection(Untreeify(content))
+	// like divs, see Untreeify(), articles would be written as raw HTML
+	Unwrap(body.Find("article"))
+	cdx.inlineAssets(body)
+
+	// pandoc only writes binary formats, eg PDF, to files
+	tmpfile, err := ioutil.TempFile("", "codex-*"+format.Ext)
+	if err != nil {
+		return nil, err
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	args = append(args, "--metadata", "title="+title, "--output", tmpfile.Name())
+	cmd := exec.Command("pandoc", args...)
+	cmd.Stdin = strings.NewReader(InnerHtml(body))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"pandoc failed: %s %s", err, strings.TrimSpace(string(out)),
+		))
+	}
+	return ioutil.ReadFile(tmpfile.Name())
+}
+
+// relevelHeads renames the heading of every node in the selection after the
+// depth of the node, relative to the shallowest one, see Treeify(). For
+// example a "## Setup" in one input and a "# Setup" in another, both at
+// depth 1, are both written as <h2>:
+//    <div class="node node-depth-1"> <div class="node-head"> <h3> ...  ==>  <h2> ...
+// Levels beyond <h6> are capped.
+func relevelHeads(sel *goquery.Selection) {
+	nodes := sel.Find(".node").AddSelection(sel.Filter(".node"))
+	base := -1
+	nodes.Each(func(i int, node *goquery.Selection) {
+		if depth, ok := nodeDepth(node); ok && (base < 0 || depth < base) {
+			base = depth
+		}
+	})
+	nodes.Each(func(i int, node *goquery.Selection) {
+		depth, ok := nodeDepth(node)
+		if !ok {
+			return
+		}
+		level := depth - base + 1
+		if level > 6 {
+			level = 6
+		}
+		node.ChildrenFiltered(".node-head").ChildrenFiltered("h1, h2, h3, h4, h5, h6").Each(
+			func(i int, head *goquery.Selection) {
+				head.Get(0).Data = fmt.Sprintf("h%d", level)
+			},
+		)
+	})
+}
+
+// nodeDepth returns the depth of a node, as given by its node-depth-N class.
+func nodeDepth(node *goquery.Selection) (int, bool) {
+	for _, class := range strings.Fields(node.AttrOr("class", "")) {
+		if strings.HasPrefix(class, "node-depth-") {
+			depth, err := strconv.Atoi(strings.TrimPrefix(class, "node-depth-"))
+			return depth, err == nil
+		}
+	}
+	return 0, false
+}
+
+// PdfEngine returns the first of LatexEngines that is installed, if any.
+func PdfEngine() (string, bool) {
+	for _, engine := range LatexEngines {
+		if _, err := exec.LookPath(engine); err == nil {
+			return engine, true
+		}
+	}
+	return "", false
+}
+
+// exportSelection returns detached copies of the articles, or the node, in
+// the given scope, along with a title for them.
+func (cdx *Codex) exportSelection(scope ExportScope) (*goquery.Selection, string, error) {
+	if scope.Node != "" {
+		ref, err := cdx.LookupNode(scope.Node)
+		if err != nil {
+			return nil, "", err
+		}
+		node, title := ref.Node, "codex"
+		if !node.HasClass("headless") && HeadText(node) != "" {
+			title = HeadText(node)
+		}
+		if node.Is("li") {
+			// a list item on its own is not valid HTML, keep its list
+			list, err := LoadHtml("<ul></ul>")
+			if err != nil {
+				return nil, "", err
+			}
+			node = list.Find("ul").AppendSelection(node)
+		}
+		return node, title, nil
+	}
+
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+	if scope.Source == "" {
+		return cdx.HtmlDoc.Find("main > article").Clone(), "codex", nil
+	}
+	codoc, ok := cdx.Inputs[scope.Source]
+	if !ok {
+		return nil, "", errors.New(fmt.Sprintf("No such input: %s", scope.Source))
+	}
+	title := codoc.Path
+	if titles := cdx.Meta[codoc.Path].Values("title"); len(titles) > 0 {
+		title = titles[0]
+	}
+	return cdx.CurrentDOMArticle(codoc).Clone(), title, nil
+}
+
+// inlineAssets replaces links to the asset endpoint, see LinkAssets(), with
+// data URLs, such that exports are self-contained:
+//    <img src="api/asset?path=fig1.png&source=notes.md">  ==>  <img src="data:image/png;base64,...">
+func (cdx *Codex) inlineAssets(sel *goquery.Selection) {
+	inline := func(elem *goquery.Selection, attr string) {
+		ref, err := url.Parse(elem.AttrOr(attr, ""))
+		if err != nil || ref.Path != "api/asset" {
+			return
+		}
+		name := ref.Query().Get("path")
+		contents, err := cdx.ReadAsset(ref.Query().Get("source"), name)
+		if err != nil {
+			log.Println("Failed to inline asset:", err)
+			return
+		}
+		mimeType := mime.TypeByExtension(path.Ext(name))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		elem.SetAttr(attr, "data:"+mimeType+";base64,"+base64.StdEncoding.EncodeToString(contents))
+	}
+	sel.Find(assetSelector).Each(func(i int, elem *goquery.Selection) {
+		inline(elem, "src")
+	})
+	sel.Find("image").Each(func(i int, elem *goquery.Selection) {
+		inline(elem, "href")
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// exportCodex returns a codex of the notes fixture at dir/a.html, with its
+// first node folded.
+func exportCodex(dir string) *Codex {
+	cdx := fixtureCodex(dir, Config{}, fixtureInput{
+		Name: "a.html", Html: "<title>Notes</title>" + fixtureNotesHtml,
+	})
+	cdx.HtmlDoc.Find(".node").First().AddClass("collapsed")
+	return cdx
+}
+
+func Test_PrintHtml(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "fig.png"), []byte("PNG"), 0644)
+	cdx := exportCodex(dir)
+
+	page, err := cdx.PrintHtml(ExportScope{})
+	assert.Nil(t, err)
+	doc, _ := LoadHtml(page)
+	assert.Equal(t, "codex", selText(doc.Find("title")))
+	assert.Contains(t, doc.Find("style").Text(), ".codex-page-break")
+	assert.Equal(t, 0, selCount(doc.Selection, "nav, .collapsed"))
+	// every top-level node but the first starts a page
+	assert.Equal(t, 2, selCount(doc.Selection, "article > .node"))
+	assert.Equal(t, "Two", HeadText(doc.Find(".codex-page-break")))
+	assert.Equal(t, "data:image/png;base64,UE5H", doc.Find("img").AttrOr("src", ""))
+
+	page, err = cdx.PrintHtml(ExportScope{Source: filepath.Join(dir, "a.html")})
+	assert.Nil(t, err)
+	doc, _ = LoadHtml(page)
+	assert.Equal(t, "Notes", selText(doc.Find("title")))
+
+	_, err = cdx.PrintHtml(ExportScope{Source: "missing.md"})
+	assert.NotNil(t, err)
+}
+
+func Test_PrintHtml_node(t *testing.T) {
+	cdx := exportCodex(t.TempDir())
+	id := cdx.HtmlDoc.Find(".node-depth-1:not(.headless)").AttrOr("id", "")
+
+	page, err := cdx.PrintHtml(ExportScope{Node: id})
+	assert.Nil(t, err)
+	doc, _ := LoadHtml(page)
+	assert.Equal(t, "Sub", selText(doc.Find("title")))
+	assert.Equal(t, 1, selCount(doc.Selection, "main > .node"))
+	assert.Equal(t, 0, selCount(doc.Selection, ".codex-page-break"))
+	// the asset could not be read and is left alone
+	src, _ := url.Parse(doc.Find("img").AttrOr("src", ""))
+	assert.Equal(t, "api/asset", src.Path)
+}
+
+func Test_relevelHeads(t *testing.T) {
+	doc, _ := LoadHtml(`<main><article></article><article></article></main>`)
+	for i, input := range []string{
+		`<h2>A</h2> <p>a</p> <h3>B</h3> <p>b</p>`,
+		`<h1>C</h1> <p>c</p> <h2>D</h2> <p>d</p>`,
+	} {
+		article, _ := LoadHtml(input)
+		Treeify(article, DefaultHeadRules)
+		doc.Find("article").Eq(i).SetHtml(InnerHtml(article.Find("body")))
+	}
+
+	articles := doc.Find("article").Clone()
+	relevelHeads(articles)
+	heads := func(sel *goquery.Selection) []string {
+		return sel.Find("h1, h2, h3, h4, h5, h6").Map(func(i int, head *goquery.Selection) string {
+			return goquery.NodeName(head) + " " + selText(head)
+		})
+	}
+	assert.Equal(t, []string{"h1 A", "h2 B", "h1 C", "h2 D"}, heads(articles))
+
+	// a subtree is re-leveled relative to its root
+	node := doc.Find(".node-depth-1:not(.headless)").Last().Clone()
+	relevelHeads(node)
+	assert.Equal(t, []string{"h1 D"}, heads(node))
+}
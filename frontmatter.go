@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metadata is the YAML front matter of an input document, eg:
+//    ---
+//    title: Weekly notes
+//    tags: [work, meetings]
+//    status: draft
+//    ---
+type Metadata map[string]interface{}
+
+// articleAttrs are the codex-* attributes of <article>s that are not
+// metadata, see Codex.DOMSkeleton() and Codex.Update().
+var articleAttrs = map[string]bool{
+	"codex-source": true,
+	"codex-path":   true,
+	"codex-mtime":  true,
+}
+
+var metaKeyRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// SplitFrontMatter separates YAML front matter from the rest of a source
+// document. The front matter lines are blanked, not removed, in the returned
+// source such that line numbers are preserved. If the document has no front
+// matter, or it is not a YAML mapping, meta is nil and source is unchanged.
+func SplitFrontMatter(source string) (meta Metadata, rest string) {
+	lines := strings.Split(source, "\n")
+	if strings.TrimSpace(strings.TrimPrefix(lines[0], "\ufeff")) != "---" {
+		return nil, source
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if line := strings.TrimSpace(lines[i]); line == "---" || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return nil, source
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(lines[1:end], "\n")), &meta); err != nil {
+		return nil, source
+	}
+	if meta == nil {
+		meta = Metadata{} // empty front matter is still front matter
+	}
+	for i := 0; i <= end; i++ {
+		lines[i] = ""
+	}
+	return meta, strings.Join(lines, "\n")
+}
+
+// Values returns the string values of a metadata key; lists have one value
+// per item, scalars have one value, and anything else has none.
+func (meta Metadata) Values(key string) []string {
+	switch value := meta[key].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if str := metaString(item); str != "" {
+				values = append(values, str)
+			}
+		}
+		return values
+	default:
+		if str := metaString(value); str != "" {
+			return []string{str}
+		}
+		return nil
+	}
+}
+
+// Attrs returns the codex-* <article> attributes for the metadata, eg
+// codex-title="Weekly notes" and codex-tags="work,meetings".
+func (meta Metadata) Attrs() map[string]string {
+	attrs := make(map[string]string)
+	for key := range meta {
+		name := "codex-" + strings.Trim(metaKeyRegex.ReplaceAllString(strings.ToLower(key), "-"), "-")
+		if articleAttrs[name] || name == "codex-" {
+			continue
+		}
+		if values := meta.Values(key); len(values) > 0 {
+			attrs[name] = strings.Join(values, ",")
+		}
+	}
+	return attrs
+}
+
+// Matches reports whether the metadata has the given value for key,
+// case-insensitively. For lists, eg tags, any item may match.
+func (meta Metadata) Matches(key string, value string) bool {
+	for _, candidate := range meta.Values(key) {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func metaString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case time.Time:
+		if value.Hour() == 0 && value.Minute() == 0 && value.Second() == 0 {
+			return value.Format("2006-01-02")
+		}
+		return ToIso8601(value)
+	case int, int64, float64, bool:
+		return fmt.Sprint(value)
+	default:
+		return ""
+	}
+}
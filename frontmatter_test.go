@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_SplitFrontMatter(t *testing.T) {
+	meta, rest := SplitFrontMatter("---\ntitle: Notes\ntags: [work, Meetings]\n---\n# H1\n")
+
+	assert.Equal(t, "\n\n\n\n# H1\n", rest)
+	assert.Equal(t, []string{"Notes"}, meta.Values("title"))
+	assert.True(t, meta.Matches("tags", "meetings"))
+	assert.False(t, meta.Matches("tags", "home"))
+	assert.Equal(t, map[string]string{
+		"codex-title": "Notes",
+		"codex-tags":  "work,Meetings",
+	}, meta.Attrs())
+}
+
+func Test_SplitFrontMatter_none(t *testing.T) {
+	// a thematic break followed by a setext heading is not front matter
+	source := "---\nHello\n"
+	meta, rest := SplitFrontMatter(source)
+	assert.Nil(t, meta)
+	assert.Equal(t, source, rest)
+
+	source = "# H1\n---\nfoo: bar\n---\n"
+	meta, rest = SplitFrontMatter(source)
+	assert.Nil(t, meta)
+	assert.Equal(t, source, rest)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pmezard/go-difflib/difflib"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryMaxRevisions = 20 // default number of revisions to look at
+	HistoryCacheSize    = 32 // number of rendered revisions kept in memory
+)
+
+// revisionFormat is the git log format parsed by parseRevision().
+const revisionFormat = "%H%x1f%an%x1f%aI%x1f%s"
+
+// commitRegex matches abbreviated and full commit hashes, and nothing that
+// git could take for an option or a ref name.
+var commitRegex = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// Revision is a single git commit that touched an input document.
+type Revision struct {
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+
+	// path of the document at this revision relative to the repository root,
+	// it changes across renames.
+	path string
+}
+
+// NodeRevision is the state of a single node at a given Revision.
+type NodeRevision struct {
+	Revision
+	Text string `json:"text"`
+	Diff string `json:"diff"` // unified diff against the previous revision
+}
+
+// History reads past revisions of input documents from the local git
+// repository they live in. It never talks to remotes.
+type History struct {
+	// the HistoryCacheSize most recently rendered revisions, see Render(),
+	// keyed by "commit:path", and in order of use, most recent first.
+	cache map[string]*list.Element
+	lru   *list.List
+	mu    sync.Mutex
+}
+
+// renderedRevision is an entry of the History cache.
+type renderedRevision struct {
+	key string
+	doc *goquery.Document
+}
+
+func NewHistory() *History {
+	return &History{cache: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (hist *History) cached(key string) (*goquery.Document, bool) {
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	elem, ok := hist.cache[key]
+	if !ok {
+		return nil, false
+	}
+	hist.lru.MoveToFront(elem)
+	return elem.Value.(*renderedRevision).doc, true
+}
+
+func (hist *History) store(key string, doc *goquery.Document) {
+	hist.mu.Lock()
+	defer hist.mu.Unlock()
+	if elem, ok := hist.cache[key]; ok {
+		hist.lru.MoveToFront(elem)
+		return
+	}
+	hist.cache[key] = hist.lru.PushFront(&renderedRevision{key: key, doc: doc})
+	for hist.lru.Len() > HistoryCacheSize {
+		oldest := hist.lru.Back()
+		hist.lru.Remove(oldest)
+		delete(hist.cache, oldest.Value.(*renderedRevision).key)
+	}
+}
+
+// git runs a git subcommand in the directory containing path.
+func git(path string, args ...string) ([]byte, error) {
+	args = append([]string{"-C", filepath.Dir(path)}, args...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"git %s failed: %s", args[2], strings.TrimSpace(stderr.String()),
+		))
+	}
+	return out, nil
+}
+
+// Log returns the most recent revisions of the given document, newest first.
+func (hist *History) Log(codoc *Document, limit int) ([]Revision, error) {
+	out, err := git(codoc.Path,
+		"log", "--follow", "--name-only", fmt.Sprintf("-n%d", limit),
+		"--format=%x1e"+revisionFormat,
+		"--", filepath.Base(codoc.Path),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []Revision
+	for _, entry := range strings.Split(string(out), "\x1e") {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		if len(lines) < 2 {
+			continue // leading empty entry, or a commit without our file
+		}
+		rev, err := parseRevision(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		rev.path = strings.TrimSpace(lines[len(lines)-1])
+		revs = append(revs, rev)
+	}
+	return revs, nil
+}
+
+// Revision returns the given document as of the given commit, which need not
+// be among the revisions returned by Log(), eg it may be older than those.
+func (hist *History) Revision(codoc *Document, commit string) (Revision, error) {
+	if !commitRegex.MatchString(commit) {
+		return Revision{}, errors.New(fmt.Sprintf("Invalid commit: %q", commit))
+	}
+	out, err := git(codoc.Path, "log", "-n1", "--format="+revisionFormat, commit, "--")
+	if err != nil {
+		return Revision{}, err
+	}
+	rev, err := parseRevision(strings.TrimSpace(string(out)))
+	if err != nil {
+		return Revision{}, err
+	}
+
+	// the path of the document at the commit is its current one, unless it
+	// was renamed since, in which case it's the old name of the oldest rename.
+	base := filepath.Base(codoc.Path)
+	out, err = git(codoc.Path, "log", "--follow", "--name-status", "--format=",
+		rev.Commit+"..HEAD", "--", base)
+	if err != nil {
+		return Revision{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if oldest := strings.Split(lines[len(lines)-1], "\t"); len(oldest) > 1 {
+		rev.path = oldest[1] // the old name, if a rename, or the only one
+	} else {
+		out, err = git(codoc.Path, "ls-files", "--full-name", "--", base)
+		if err != nil {
+			return Revision{}, err
+		}
+		rev.path = strings.TrimSpace(string(out))
+	}
+	if _, err := git(codoc.Path, "cat-file", "-e", rev.Commit+":"+rev.path); err != nil {
+		return Revision{}, errors.New(fmt.Sprintf("%s is not in commit %s", codoc.Path, commit))
+	}
+	return rev, nil
+}
+
+// parseRevision parses a commit formatted as revisionFormat.
+func parseRevision(line string) (Revision, error) {
+	fields := strings.Split(line, "\x1f")
+	if len(fields) != 4 {
+		return Revision{}, errors.New(fmt.Sprintf("Unexpected git log output: %q", line))
+	}
+	date, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{Commit: fields[0], Author: fields[1], Date: date, Subject: fields[3]}, nil
+}
+
+// Render returns the treeified DOM of the given document at the given
+// revision. Recently rendered revisions are cached, they never change.
+func (hist *History) Render(cdx *Codex, codoc *Document, rev Revision) (*goquery.Document, error) {
+	key := rev.Commit + ":" + rev.path
+	if doc, ok := hist.cached(key); ok {
+		return doc, nil
+	}
+
+	contents, err := git(codoc.Path, "show", key)
+	if err != nil {
+		return nil, err
+	}
+	path, err := TempSource(filepath.Ext(codoc.Path), contents)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	doc, _, err := cdx.Render(path)
+	if err != nil {
+		return nil, err
+	}
+	LinkAssets(doc, codoc.Path)
+	hist.store(key, doc)
+	return doc, nil
+}
+
+// NodeHistory returns the past versions of the given node, newest first,
+// skipping revisions that did not change the node's text.
+//
+// Node ids are content hashes and do not survive edits, so nodes are matched
+// across revisions by their HeadPath. Consequently the history of a headless
+// node is that of its closest headed ancestor.
+func (hist *History) NodeHistory(cdx *Codex, ref *NodeRef, limit int) ([]NodeRevision, error) {
+	revs, err := hist.Log(ref.Source, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeRevs []NodeRevision
+	for _, rev := range revs {
+		doc, err := hist.Render(cdx, ref.Source, rev)
+		if err != nil {
+			return nil, err
+		}
+		node := findByHeadPath(doc, ref.HeadPath)
+		if node.Length() == 0 {
+			break // node did not exist before this revision
+		}
+		text := PlainText(node)
+		if n := len(nodeRevs); n > 0 && nodeRevs[n-1].Text == text {
+			// unchanged, the older revision is the more accurate one
+			nodeRevs[n-1].Revision = rev
+			continue
+		}
+		nodeRevs = append(nodeRevs, NodeRevision{Revision: rev, Text: text})
+	}
+
+	for i := range nodeRevs {
+		prev := ""
+		if i+1 < len(nodeRevs) {
+			prev = nodeRevs[i+1].Text
+		}
+		nodeRevs[i].Diff = textDiff(prev, nodeRevs[i].Text)
+	}
+	return nodeRevs, nil
+}
+
+// findByHeadPath returns the first node in doc with the given HeadPath.
+func findByHeadPath(doc *goquery.Document, path []string) *goquery.Selection {
+	if len(path) == 0 {
+		return doc.Find("body")
+	}
+	return doc.Find(".node:not(.headless)").FilterFunction(
+		func(i int, node *goquery.Selection) bool {
+			if HeadText(node) != path[len(path)-1] {
+				return false
+			}
+			return strings.Join(HeadPath(node), "\n") == strings.Join(path, "\n")
+		}).First()
+}
+
+func textDiff(before string, after string) string {
+	lines := func(text string) []string {
+		if text == "" {
+			return nil
+		}
+		return difflib.SplitLines(text)
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:       lines(before),
+		B:       lines(after),
+		Context: 3,
+	})
+	if err != nil {
+		return ""
+	}
+	return diff
+}
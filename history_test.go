@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitFixture runs git in the given directory and returns its output,
+// failing the test on errors.
+func gitFixture(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=codex", "GIT_AUTHOR_EMAIL=codex@example.com",
+		"GIT_COMMITTER_NAME=codex", "GIT_COMMITTER_EMAIL=codex@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// historyFixture commits each of the given contents of notes.html in a new
+// repository, and returns the repository and the commits, oldest first.
+func historyFixture(t *testing.T, contents ...string) (string, []string) {
+	dir := t.TempDir()
+	gitFixture(t, dir, "init", "-q")
+	var commits []string
+	for idx, content := range contents {
+		ioutil.WriteFile(filepath.Join(dir, "notes.html"), []byte(content), 0644)
+		gitFixture(t, dir, "add", "notes.html")
+		gitFixture(t, dir, "commit", "-q", "-m", []string{"first", "edit", "rename"}[idx])
+		commits = append(commits, gitFixture(t, dir, "rev-parse", "HEAD"))
+	}
+	return dir, commits
+}
+
+func nodeByHead(doc *goquery.Document, head string) *goquery.Selection {
+	return doc.Find(".node").FilterFunction(func(i int, node *goquery.Selection) bool {
+		return HeadText(node) == head
+	}).First()
+}
+
+func Test_NodeHistory(t *testing.T) {
+	dir, commits := historyFixture(t,
+		`<h1>Plans</h1> <p>draft</p> <h1>Other</h1> <p>one</p>`,
+		`<h1>Plans</h1> <p>draft</p> <h1>Other</h1> <p>two</p>`,
+		`<h1>Goals</h1> <p>draft</p> <h1>Other</h1> <p>two</p>`,
+	)
+	// renames of the input itself are followed
+	gitFixture(t, dir, "mv", "notes.html", "journal.html")
+	gitFixture(t, dir, "commit", "-q", "-m", "move")
+	path := filepath.Join(dir, "journal.html")
+	cdx, err := NewCodex([]string{path}, Config{History: true})
+	assert.Nil(t, err)
+
+	revs, err := cdx.history.Log(cdx.Inputs[path], HistoryMaxRevisions)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(revs))
+	assert.Equal(t, "move", revs[0].Subject)
+	assert.Equal(t, "notes.html", revs[3].path)
+
+	// an edit is a revision, unchanged revisions are skipped
+	ref, err := cdx.LookupNode(nodeByHead(cdx.HtmlDoc, "Other").AttrOr("id", ""))
+	assert.Nil(t, err)
+	nodeRevs, err := cdx.history.NodeHistory(cdx, ref, HistoryMaxRevisions)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(nodeRevs))
+	assert.Equal(t, commits[1], nodeRevs[0].Commit)
+	assert.Equal(t, "Other\ntwo", nodeRevs[0].Text)
+	assert.Contains(t, nodeRevs[0].Diff, "-one\n+two\n")
+	assert.Equal(t, commits[0], nodeRevs[1].Commit)
+
+	// nodes are matched by heading, a renamed heading starts a new history
+	ref, err = cdx.LookupNode(nodeByHead(cdx.HtmlDoc, "Goals").AttrOr("id", ""))
+	assert.Nil(t, err)
+	nodeRevs, err = cdx.history.NodeHistory(cdx, ref, HistoryMaxRevisions)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nodeRevs))
+	assert.Equal(t, commits[2], nodeRevs[0].Commit)
+	assert.Contains(t, nodeRevs[0].Diff, "+Goals\n")
+}
+
+func Test_History_Revision(t *testing.T) {
+	dir, commits := historyFixture(t, `<h1>Plans</h1> <p>one</p>`, `<h1>Plans</h1> <p>two</p>`)
+	gitFixture(t, dir, "mv", "notes.html", "journal.html")
+	gitFixture(t, dir, "commit", "-q", "-m", "move")
+	path := filepath.Join(dir, "journal.html")
+	cdx, err := NewCodex([]string{path}, Config{History: true})
+	assert.Nil(t, err)
+	codoc := cdx.Inputs[path]
+
+	// older than the most recent revisions, and under its old name
+	rev, err := cdx.history.Revision(codoc, commits[0][:8])
+	assert.Nil(t, err)
+	assert.Equal(t, commits[0], rev.Commit)
+	assert.Equal(t, "notes.html", rev.path)
+	doc, err := cdx.history.Render(cdx, codoc, rev)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", selText(doc.Find("p")))
+
+	rev, err = cdx.history.Revision(codoc, gitFixture(t, dir, "rev-parse", "HEAD"))
+	assert.Nil(t, err)
+	assert.Equal(t, "journal.html", rev.path)
+
+	for _, commit := range []string{"--all", "HEAD", "deadbeef"} {
+		_, err = cdx.history.Revision(codoc, commit)
+		assert.NotNil(t, err, commit)
+	}
+}
+
+func Test_History_cache(t *testing.T) {
+	hist := NewHistory()
+	for idx := 0; idx <= HistoryCacheSize; idx++ {
+		hist.store(string(rune('a'+idx)), &goquery.Document{})
+		if idx == 0 {
+			continue
+		}
+		_, ok := hist.cached("a") // keep "a" recently used
+		assert.True(t, ok)
+	}
+	assert.Equal(t, HistoryCacheSize, hist.lru.Len())
+	_, ok := hist.cached("b") // least recently used
+	assert.False(t, ok)
+	_, ok = hist.cached("a")
+	assert.True(t, ok)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/yosssi/gohtml"
+	"golang.org/x/net/html"
 	"log"
 	"strings"
 )
@@ -38,3 +39,62 @@ func LoadHtml(html string) (*goquery.Document, error) {
 	}
 	return doc, nil
 }
+
+// blockElements are the elements after which PlainText starts a new line.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "pre": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"tr": true, "dt": true, "dd": true, "hr": true, "br": true,
+	"table": true, "ul": true, "ol": true, "dl": true,
+}
+
+// PlainText returns the text contents of the selection with one line per
+// block-level element, eg each paragraph and list item on its own line.
+func PlainText(sel *goquery.Selection) string {
+	var buf strings.Builder
+	var walk func(*html.Node, bool)
+	walk = func(node *html.Node, pre bool) {
+		if node.Type == html.TextNode {
+			if !pre {
+				// line breaks in source HTML, eg pandoc's wrapping, are spaces
+				buf.WriteString(strings.ReplaceAll(node.Data, "\n", " "))
+				return
+			}
+			buf.WriteString(node.Data)
+			return
+		}
+		pre = pre || node.Type == html.ElementNode && node.Data == "pre"
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, pre)
+		}
+		if node.Type == html.ElementNode && blockElements[node.Data] {
+			buf.WriteString("\n")
+		}
+	}
+	for _, node := range sel.Nodes {
+		walk(node, false)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap replaces each element in the selection with its children.
+func Unwrap(sel *goquery.Selection) {
+	for _, node := range sel.Nodes {
+		if node.Parent == nil {
+			continue
+		}
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			node.Parent.InsertBefore(child, node)
+		}
+		node.Parent.RemoveChild(node)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_PlainText(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1>Title  with
+		   spaces</h1>
+		<p>One <em>two</em></p>
+		<ul><li>three</li><li>four<br>five</li></ul>
+		<p>  </p>
+		<pre>six
+seven</pre>
+	`)
+	assert.Equal(t, "Title with spaces\nOne two\nthree\nfour\nfive\nsix\nseven", PlainText(doc.Find("body")))
+	assert.Equal(t, "One two", PlainText(doc.Find("p").First()))
+}
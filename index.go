@@ -1,28 +1,44 @@
 package main
 
+// The scripts of codex's dependencies, the only ones besides codex's own that
+// ContentSecurityPolicy allows.
+const (
+	jqueryScript  = "https://code.jquery.com/jquery-3.6.0.min.js"
+	markScript    = "https://cdnjs.cloudflare.com/ajax/libs/mark.js/8.11.1/jquery.mark.min.js"
+	mathjaxScript = "https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml-full.js"
+)
+
+// CodexOutputTemplate is the page shell of codex, in html/template syntax.
+// Themes may override its blocks, see Theme:
+//  - "head": the contents of <head>, should end with {{template "theme" .}},
+//  - "nav": search and file navigation, used by codex.js,
+//  - "main": the <main> element that articles are appended to.
 const CodexOutputTemplate = `
 <!DOCTYPE html>
 <html xmlns="http://www.w3.org/1999/xhtml" lang="" xml:lang="">
 <head>
+  {{- block "head" .}}
   <meta charset="utf-8"/>
   <meta name="viewport" content="width=device-width, initial-scale=1.0, user-scalable=yes"/>
   <title>codex</title>
 
   <link rel="icon" type="image/svg" href="static/codex.svg"/>
 
-  <script src="https://code.jquery.com/jquery-3.6.0.min.js"> </script>
-  <script src="https://unpkg.com/lunr@2.3.9/lunr.js"></script>
-  <script src="https://cdnjs.cloudflare.com/ajax/libs/mark.js/8.11.1/jquery.mark.min.js"></script>
-  <script src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml-full.js" type="text/javascript"></script>
+  <script src="` + jqueryScript + `"> </script>
+  <script src="` + markScript + `"></script>
+  <script src="` + mathjaxScript + `" type="text/javascript"></script>
 
   <link href="https://fonts.googleapis.com/css2?family=Inter&family=Ubuntu+Mono&display=swap" rel="stylesheet">
 
   <script src="static/codex.js"></script>
   <link rel="stylesheet" href="static/pandoc.css"/>
   <link rel="stylesheet" href="static/codex.css"/>
+  {{template "theme" .}}
+  {{- end}}
 </head>
 
 <body>
+  {{- block "nav" .}}
   <nav>
 	<div id="search">
 		<input type="text" placeholder="search" autocomplete=off name="search-input" size="20">
@@ -33,12 +49,73 @@ const CodexOutputTemplate = `
 		<!-- codex file navigation -->
 	</div>
   </nav>
+  {{- end}}
 
+  {{- block "main" .}}
   <main>
     <!-- codex contents -->
   </main>
+  {{- end}}
 
   <div id="full-screen-modal" class="inactive"> <!-- used by codex.js --> </div>
 </body>
 
+</html>
+
+{{- define "theme"}}
+  {{- range .Stylesheets}}
+  <link rel="stylesheet" href="{{.Href}}"{{if .Media}} media="{{.Media}}"{{end}}/>
+  {{- end}}
+  {{- range .Scripts}}
+  <script src="{{.}}"></script>
+  {{- end}}
+{{- end}}`
+
+// CollectionsIndexTemplate lists the collections of a server, see Server. It
+// shares the "theme" template with CodexOutputTemplate.
+const CollectionsIndexTemplate = `
+<!DOCTYPE html>
+<html lang="">
+<head>
+  <meta charset="utf-8"/>
+  <meta name="viewport" content="width=device-width, initial-scale=1.0, user-scalable=yes"/>
+  <title>codex</title>
+
+  <link rel="icon" type="image/svg" href="static/codex.svg"/>
+  <link href="https://fonts.googleapis.com/css2?family=Inter&family=Ubuntu+Mono&display=swap" rel="stylesheet">
+  <link rel="stylesheet" href="static/codex.css"/>
+  {{template "theme" .Theme}}
+</head>
+
+<body>
+  <main id="collections">
+    <h1>Collections</h1>
+    <ul>
+    {{- range .Collections}}
+      <li><a href="c/{{.Name}}/">{{.Name}}</a> <span class="collection-size">{{len .Codex.Inputs}} input(s)</span></li>
+    {{- end}}
+    </ul>
+  </main>
+</body>
+
+</html>`
+
+// PrintOutputTemplate is the page shell of exports, see Codex.Export(). Its
+// stylesheets are inlined, such that exports are self-contained.
+const PrintOutputTemplate = `
+<!DOCTYPE html>
+<html lang="">
+<head>
+  <meta charset="utf-8"/>
+  <title>codex</title>
+  <script src="` + mathjaxScript + `" type="text/javascript"></script>
+  <style> /* pandoc.css and print.css */ </style>
+</head>
+
+<body>
+  <main>
+    <!-- exported contents -->
+  </main>
+</body>
+
 </html>`
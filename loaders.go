@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// htmlStripSelector matches the parts of saved web pages and EPUB chapters
+// that are not content.
+const htmlStripSelector = `nav, script, style, noscript, template, iframe, form, aside,
+	link, [role="navigation"], [role="banner"], [role="contentinfo"]`
+
+// assetSelector matches the elements whose src refers to a file, see
+// LinkAssets().
+const assetSelector = "img[src], source[src], video[src], audio[src]"
+
+// Load reads the input at path as HTML along with its front matter, if any,
+// and the source text that nodes are located in, see AnnotateSourceLines().
+// HTML and EPUB inputs are read as is, notebooks are read cell by cell, and
+// all other formats are converted by pandoc.
+func (cdx *Codex) Load(path string) (*goquery.Document, Metadata, string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		doc, meta, err := LoadHtmlInput(string(contents))
+		return doc, meta, string(contents), err
+	case ".epub":
+		doc, meta, err := LoadEpub(path)
+		// there are no source lines in a zip archive
+		return doc, meta, "", err
+	case ".ipynb":
+		// nor are there meaningful ones in notebook JSON
+		doc, err := cdx.LoadNotebook(path)
+		return doc, nil, "", err
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	meta, source := SplitFrontMatter(string(contents))
+	if meta != nil {
+		// pandoc drops or renders front matter depending on input format,
+		// have it parse the rest only.
+		path, err = TempSource(filepath.Ext(path), []byte(source))
+		if err != nil {
+			return nil, nil, "", err
+		}
+		defer os.Remove(path)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".md" || ext == ".markdown" {
+		doc, err := cdx.LoadMarkdown(source)
+		return doc, meta, source, err
+	}
+	doc, err := cdx.runPandoc(path)
+	return doc, meta, source, err
+}
+
+// MarkdownReader is the pandoc reader of markdown inputs. Unlike pandoc's own
+// markdown, it can report source positions, see AnnotateSourceLines().
+const MarkdownReader = "commonmark_x+sourcepos"
+
+// LoadMarkdown converts markdown to HTML with MarkdownReader, leaving the
+// source positions of blocks in data-pos attributes, see UnwrapSourcePos().
+func (cdx *Codex) LoadMarkdown(source string) (*goquery.Document, error) {
+	converted, err := cdx.pandocConvert(source, MarkdownReader, "html", "--mathjax")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := LoadHtml(converted)
+	if err != nil {
+		return nil, err
+	}
+	UnwrapSourcePos(doc)
+	return doc, nil
+}
+
+// LoadHtmlInput extracts the main content of a web page, eg a saved article,
+// into a document of its own. The content is the page's <main> if it has one,
+// or its only <article>, and otherwise its whole <body> minus top level
+// headers and footers. Navigation, scripts, styles, and the like are
+// stripped. The page title and author, if any, are returned as metadata.
+func LoadHtmlInput(contents string) (*goquery.Document, Metadata, error) {
+	page, err := LoadHtml(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := Metadata{}
+	if title := strings.TrimSpace(page.Find("head > title").First().Text()); title != "" {
+		meta["title"] = title
+	}
+	if author := page.Find(`meta[name="author"]`).AttrOr("content", ""); author != "" {
+		meta["author"] = author
+	}
+	if len(meta) == 0 {
+		meta = nil
+	}
+
+	content := page.Find(`main, [role="main"]`).First()
+	if content.Length() == 0 && page.Find("article").Length() == 1 {
+		content = page.Find("article")
+	}
+	if content.Length() == 0 {
+		content = page.Find("body")
+		content.ChildrenFiltered("header, footer").Remove()
+	}
+	content.Find(htmlStripSelector).Remove()
+	stripEventHandlers(content)
+
+	doc, err := LoadHtml(InnerHtml(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, meta, nil
+}
+
+// stripEventHandlers removes inline event handlers, eg onclick, from all
+// elements in the selection.
+func stripEventHandlers(sel *goquery.Selection) {
+	sel.Find("*").Each(func(i int, elem *goquery.Selection) {
+		node := elem.Get(0)
+		attrs := node.Attr[:0]
+		for _, attr := range node.Attr {
+			if !strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+				attrs = append(attrs, attr)
+			}
+		}
+		node.Attr = attrs
+	})
+}
+
+// epubContainer is META-INF/container.xml of an EPUB, it points to the
+// package document.
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage is the package document, aka OPF, of an EPUB. It lists the
+// files in the book and the reading order of its chapters, aka spine.
+type epubPackage struct {
+	Titles   []string `xml:"metadata>title"`
+	Creators []string `xml:"metadata>creator"`
+	Manifest []struct {
+		Id        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// LoadEpub reads the chapters of an EPUB in spine order into a single
+// document. Within chapters, image sources are resolved to paths in the
+// archive, see ReadAsset(), and links to other chapters are reduced to their
+// fragment. The book title and authors are returned as metadata.
+func LoadEpub(epubPath string) (*goquery.Document, Metadata, error) {
+	archive, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer archive.Close()
+
+	var container epubContainer
+	if err := readZipXml(&archive.Reader, "META-INF/container.xml", &container); err != nil {
+		return nil, nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, nil, errors.New(fmt.Sprintf("No package document in %s", epubPath))
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var pkg epubPackage
+	if err := readZipXml(&archive.Reader, opfPath, &pkg); err != nil {
+		return nil, nil, err
+	}
+
+	var meta Metadata
+	if len(pkg.Titles) > 0 {
+		meta = Metadata{"title": strings.TrimSpace(pkg.Titles[0])}
+		if len(pkg.Creators) > 0 {
+			var authors []interface{}
+			for _, creator := range pkg.Creators {
+				authors = append(authors, strings.TrimSpace(creator))
+			}
+			meta["author"] = authors
+		}
+	}
+
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			hrefs[item.Id] = item.Href
+		}
+	}
+	doc, err := LoadHtml("")
+	if err != nil {
+		return nil, nil, err
+	}
+	body := doc.Find("body")
+	for _, itemref := range pkg.Spine {
+		href, ok := hrefs[itemref.IdRef]
+		if !ok {
+			continue
+		}
+		href, err := url.PathUnescape(href)
+		if err != nil {
+			return nil, nil, err
+		}
+		chapterPath := path.Join(path.Dir(opfPath), href)
+		contents, err := readZipFile(&archive.Reader, chapterPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		chapter, err := LoadHtml(string(contents))
+		if err != nil {
+			return nil, nil, err
+		}
+		content := chapter.Find("body")
+		content.Find(htmlStripSelector).Remove()
+		stripEventHandlers(content)
+		resolveChapterLinks(content, path.Dir(chapterPath))
+		body.AppendHtml(InnerHtml(content))
+	}
+	return doc, meta, nil
+}
+
+// resolveChapterLinks makes the references of an EPUB chapter in dir valid
+// once the chapter is merged with others: images refer to their path in the
+// archive, and links to other chapters refer to their fragment only.
+func resolveChapterLinks(content *goquery.Selection, dir string) {
+	content.Find(assetSelector).Each(func(i int, elem *goquery.Selection) {
+		if src, ok := relativeUrl(elem.AttrOr("src", "")); ok {
+			elem.SetAttr("src", path.Join(dir, src.Path))
+		}
+	})
+	// svg images, eg covers, use href or xlink:href
+	content.Find("image").Each(func(i int, elem *goquery.Selection) {
+		if src, ok := relativeUrl(elem.AttrOr("href", "")); ok {
+			elem.SetAttr("href", path.Join(dir, src.Path))
+		}
+	})
+	content.Find("a[href]").Each(func(i int, link *goquery.Selection) {
+		if href, ok := relativeUrl(link.AttrOr("href", "")); ok && href.Fragment != "" {
+			link.SetAttr("href", "#"+href.Fragment)
+		}
+	})
+}
+
+func readZipFile(archive *zip.Reader, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func readZipXml(archive *zip.Reader, name string, v interface{}) error {
+	contents, err := readZipFile(archive, name)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(contents, v)
+}
+
+// relativeUrl parses a URL and reports whether it's a relative reference to
+// a file, eg "images/fig1.png" but not "/fig1.png" or "data:...".
+func relativeUrl(ref string) (*url.URL, bool) {
+	parsed, err := url.Parse(ref)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.Path == "" {
+		return nil, false
+	}
+	return parsed, !strings.HasPrefix(parsed.Path, "/")
+}
+
+// LinkAssets points relative image and media sources in the doc rendered
+// from the given input to the asset endpoint, see ReadAsset(), and returns
+// the names of the linked assets, see AssetName():
+//    <img src="fig1.png">  ==>  <img src="api/asset?path=fig1.png&source=notes.md">
+func LinkAssets(doc *goquery.Document, source string) map[string]bool {
+	names := make(map[string]bool)
+	link := func(elem *goquery.Selection, attr string) {
+		if ref, ok := relativeUrl(elem.AttrOr(attr, "")); ok {
+			query := url.Values{"source": {source}, "path": {ref.Path}}
+			elem.SetAttr(attr, "api/asset?"+query.Encode())
+			names[AssetName(ref.Path)] = true
+		}
+	}
+	doc.Find(assetSelector).Each(func(i int, elem *goquery.Selection) {
+		link(elem, "src")
+	})
+	doc.Find("image").Each(func(i int, elem *goquery.Selection) {
+		link(elem, "href")
+	})
+	return names
+}
+
+// AssetName normalizes the name of an asset relative to its input, such that
+// it cannot refer to anything outside of the input's directory:
+//    images/../fig1.png  ==>  fig1.png
+//    ../../etc/passwd    ==>  etc/passwd
+func AssetName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// ReadAsset returns the contents of a file referenced by the given input, eg
+// an image. Names are relative to the directory of the input, or to the root
+// of the archive for EPUBs, and cannot refer to anything outside of those.
+// Any such file can be read, see Codex.ReadAsset() for what inputs reference.
+func ReadAsset(source string, name string) ([]byte, error) {
+	name = AssetName(name)
+	if name == "" {
+		return nil, errors.New("Empty asset path")
+	}
+	if strings.ToLower(filepath.Ext(source)) == ".epub" {
+		archive, err := zip.OpenReader(source)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		return readZipFile(&archive.Reader, name)
+	}
+	return ioutil.ReadFile(filepath.Join(filepath.Dir(source), filepath.FromSlash(name)))
+}
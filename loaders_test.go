@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_LoadHtmlInput(t *testing.T) {
+	doc, meta, err := LoadHtmlInput(`
+		<html><head><title> Saved page </title><style>p {}</style></head>
+		<body>
+			<header><a href="/">Home</a></header>
+			<nav><ul><li>Menu</li></ul></nav>
+			<main>
+				<h1>Article</h1>
+				<p onclick="alert(1)">Hello World</p>
+				<script>alert(2)</script>
+				<aside>Related</aside>
+			</main>
+			<footer>Copyright</footer>
+		</body></html>
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, "Saved page", meta["title"])
+	assert.Equal(t, "Article", selText(doc.Find("body > h1")))
+	assert.Equal(t, "", doc.Find("p").AttrOr("onclick", ""))
+	assert.Equal(t, 0, selCount(doc.Selection, "nav, script, style, aside, header, footer"))
+
+	Treeify(doc, DefaultHeadRules)
+	assert.Equal(t, "Hello World", selText(doc.Find(".node-depth-1")))
+}
+
+func Test_LoadEpub(t *testing.T) {
+	files := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+			<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+			  <rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles>
+			</container>`,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+			<package xmlns="http://www.idpf.org/2007/opf" xmlns:dc="http://purl.org/dc/elements/1.1/">
+			  <metadata><dc:title>A Book</dc:title><dc:creator>Jane</dc:creator></metadata>
+			  <manifest>
+			    <item id="c2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+			    <item id="c1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+			    <item id="fig" href="images/fig.png" media-type="image/png"/>
+			  </manifest>
+			  <spine><itemref idref="c1"/><itemref idref="c2"/></spine>
+			</package>`,
+		"OEBPS/text/ch1.xhtml": `<html><body><section>
+			  <h1>One</h1><p><img src="../images/fig.png"/> See <a href="ch2.xhtml#two">two</a>.</p>
+			</section></body></html>`,
+		"OEBPS/text/ch2.xhtml": `<html><body><h1 id="two">Two</h1><p>The end</p></body></html>`,
+		"OEBPS/images/fig.png": "not really a png",
+	}
+	tmpfile, err := ioutil.TempFile("", "codex-temp-*.epub")
+	assert.Nil(t, err)
+	defer os.Remove(tmpfile.Name())
+	archive := zip.NewWriter(tmpfile)
+	for name, contents := range files {
+		writer, err := archive.Create(name)
+		assert.Nil(t, err)
+		writer.Write([]byte(contents))
+	}
+	assert.Nil(t, archive.Close())
+	assert.Nil(t, tmpfile.Close())
+
+	doc, meta, err := LoadEpub(tmpfile.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "A Book", meta["title"])
+	assert.Equal(t, []interface{}{"Jane"}, meta["author"])
+	assert.Equal(t, "One", selText(doc.Find("h1").First()))
+	assert.Equal(t, "Two", selText(doc.Find("h1").Last()))
+	assert.Equal(t, "#two", doc.Find("a").AttrOr("href", ""))
+	assert.Equal(t, "OEBPS/images/fig.png", doc.Find("img").AttrOr("src", ""))
+
+	asset, err := ReadAsset(tmpfile.Name(), "OEBPS/images/fig.png")
+	assert.Nil(t, err)
+	assert.Equal(t, "not really a png", string(asset))
+
+	assert.Equal(t, map[string]bool{"OEBPS/images/fig.png": true}, LinkAssets(doc, "book.epub"))
+	assert.Equal(t, "api/asset?path=OEBPS%2Fimages%2Ffig.png&source=book.epub", doc.Find("img").AttrOr("src", ""))
+
+	Normalize(doc, DefaultHeadRules)
+	Treeify(doc, DefaultHeadRules)
+	assert.Equal(t, 2, doc.Find(".node-depth-0").Length())
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BuildBuckets are the upper bounds, in seconds, of the build duration
+// histogram, see WriteMetrics().
+var BuildBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// BuildStatus is the state of the builds of a single input, see BuildStats.
+type BuildStatus struct {
+	Source   string    `json:"source"`
+	State    string    `json:"state"` // building, ok, or failed
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"` // of the last finished build, in seconds
+	Error    string    `json:"error"`    // of the last build, if it failed
+	Builds   int       `json:"builds"`   // finished builds, including failures
+	Failures int       `json:"failures"`
+}
+
+// BuildStats records the builds of a codex, see Codex.Update(), for the status
+// and metrics endpoints. It's safe for concurrent use.
+type BuildStats struct {
+	// pandoc is the number of pandoc conversions waiting for, or holding, a
+	// slot in the pandoc pool, see Codex.runPandoc().
+	// note: first in the struct for 64-bit alignment of atomic operations.
+	pandoc int64
+
+	mu     sync.Mutex
+	inputs map[string]*BuildStatus
+	// buckets are the counts of finished builds per BuildBuckets, each
+	// counted only in the first bucket it fits in.
+	buckets []int
+	sum     float64
+	count   int
+}
+
+func NewBuildStats() *BuildStats {
+	return &BuildStats{
+		inputs:  make(map[string]*BuildStatus),
+		buckets: make([]int, len(BuildBuckets)),
+	}
+}
+
+func (stats *BuildStats) started(source string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	status, ok := stats.inputs[source]
+	if !ok {
+		status = &BuildStatus{Source: source}
+		stats.inputs[source] = status
+	}
+	status.State = "building"
+	status.Started = time.Now()
+}
+
+func (stats *BuildStats) finished(source string, elapsed time.Duration, err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	status, ok := stats.inputs[source]
+	if !ok {
+		status = &BuildStatus{Source: source}
+		stats.inputs[source] = status
+	}
+	status.Duration = elapsed.Seconds()
+	status.Builds++
+	status.State, status.Error = "ok", ""
+	if err != nil {
+		status.State, status.Error = "failed", err.Error()
+		status.Failures++
+	}
+
+	stats.sum += elapsed.Seconds()
+	stats.count++
+	for idx, bound := range BuildBuckets {
+		if elapsed.Seconds() <= bound {
+			stats.buckets[idx]++
+			break
+		}
+	}
+}
+
+// Inputs returns a copy of the build states of all inputs, ordered by path.
+func (stats *BuildStats) Inputs() []BuildStatus {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	statuses := []BuildStatus{}
+	for _, status := range stats.inputs {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Source < statuses[j].Source
+	})
+	return statuses
+}
+
+// PandocQueue returns the number of pandoc conversions in flight.
+func (stats *BuildStats) PandocQueue() int64 {
+	return atomic.LoadInt64(&stats.pandoc)
+}
+
+// histogram returns the cumulative counts of builds per BuildBuckets, along
+// with the sum of their durations and their count.
+func (stats *BuildStats) histogram() ([]int, float64, int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	cumulative := make([]int, len(stats.buckets))
+	total := 0
+	for idx, count := range stats.buckets {
+		total += count
+		cumulative[idx] = total
+	}
+	return cumulative, stats.sum, stats.count
+}
+
+// runPandoc converts a file with the pandoc pool and keeps count of the
+// conversions in flight, see BuildStats.
+func (cdx *Codex) runPandoc(path string) (*goquery.Document, error) {
+	defer cdx.pandocSlot()()
+	return cdx.pandocPool.Run(path)
+}
+
+// pandocConvert is PandocConvert() under the same concurrency limit and
+// accounting as the pandoc pool, see runPandoc().
+func (cdx *Codex) pandocConvert(contents string, from string, to string, options ...string) (string, error) {
+	defer cdx.pandocSlot()()
+	return PandocConvert(contents, from, to, options...)
+}
+
+// pandocSlot waits for one of the PandocConcurrency slots that all pandoc
+// subprocesses of a codex share, counting the wait as queued, and returns the
+// function that releases it.
+func (cdx *Codex) pandocSlot() func() {
+	atomic.AddInt64(&cdx.stats.pandoc, 1)
+	cdx.pandocSlots <- struct{}{}
+	return func() {
+		<-cdx.pandocSlots
+		atomic.AddInt64(&cdx.stats.pandoc, -1)
+	}
+}
+
+// handleStatus serves the build state of each input, the pandoc queue depth,
+// and the number of connected websockets:
+//    GET /api/status
+func (col *Collection) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]interface{}{
+		"inputs":       col.Codex.stats.Inputs(),
+		"pandoc_queue": col.Codex.stats.PandocQueue(),
+		"websockets":   col.WebSocketCount(),
+	})
+}
+
+// handleMetrics serves the metrics of all collections, see WriteMetrics():
+//    GET /metrics
+func (srv *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, srv.Collections)
+}
+
+// promEscaper escapes label values in the Prometheus text format.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes build and connection metrics of the given collections
+// in the Prometheus text format, labeled by collection, eg:
+//    codex_builds_total{collection="notes",source="todo.md",result="ok"} 3
+func WriteMetrics(w io.Writer, cols []*Collection) {
+	family := func(name string, kind string, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	label := func(col *Collection) string {
+		return fmt.Sprintf(`collection="%s"`, promEscaper.Replace(col.Name))
+	}
+
+	family("codex_build_duration_seconds", "histogram", "Time it takes to build an input.")
+	for _, col := range cols {
+		buckets, sum, count := col.Codex.stats.histogram()
+		for idx, bound := range BuildBuckets {
+			fmt.Fprintf(w, "codex_build_duration_seconds_bucket{%s,le=\"%g\"} %d\n", label(col), bound, buckets[idx])
+		}
+		fmt.Fprintf(w, "codex_build_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label(col), count)
+		fmt.Fprintf(w, "codex_build_duration_seconds_sum{%s} %g\n", label(col), sum)
+		fmt.Fprintf(w, "codex_build_duration_seconds_count{%s} %d\n", label(col), count)
+	}
+
+	family("codex_builds_total", "counter", "Builds of each input, by result.")
+	for _, col := range cols {
+		for _, status := range col.Codex.stats.Inputs() {
+			source := promEscaper.Replace(status.Source)
+			fmt.Fprintf(w, "codex_builds_total{%s,source=\"%s\",result=\"ok\"} %d\n",
+				label(col), source, status.Builds-status.Failures)
+			fmt.Fprintf(w, "codex_builds_total{%s,source=\"%s\",result=\"failed\"} %d\n",
+				label(col), source, status.Failures)
+		}
+	}
+
+	family("codex_build_failed", "gauge", "Whether the last build of each input failed.")
+	for _, col := range cols {
+		for _, status := range col.Codex.stats.Inputs() {
+			failed := 0
+			if status.State == "failed" {
+				failed = 1
+			}
+			fmt.Fprintf(w, "codex_build_failed{%s,source=\"%s\"} %d\n",
+				label(col), promEscaper.Replace(status.Source), failed)
+		}
+	}
+
+	family("codex_pandoc_queue", "gauge", "Pandoc conversions waiting or running.")
+	for _, col := range cols {
+		fmt.Fprintf(w, "codex_pandoc_queue{%s} %d\n", label(col), col.Codex.stats.PandocQueue())
+	}
+
+	family("codex_websockets", "gauge", "Connected websocket clients.")
+	for _, col := range cols {
+		fmt.Fprintf(w, "codex_websockets{%s} %d\n", label(col), col.WebSocketCount())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_BuildStats(t *testing.T) {
+	stats := NewBuildStats()
+	stats.started("a.md")
+	assert.Equal(t, "building", stats.Inputs()[0].State)
+
+	stats.finished("a.md", 80*time.Millisecond, nil)
+	stats.started("b.md")
+	stats.finished("b.md", 2*time.Second, errors.New("pandoc failed"))
+	stats.started("a.md")
+	stats.finished("a.md", 40*time.Millisecond, nil)
+
+	inputs := stats.Inputs()
+	assert.Equal(t, []string{"a.md", "b.md"}, []string{inputs[0].Source, inputs[1].Source})
+	assert.Equal(t, "ok", inputs[0].State)
+	assert.Equal(t, 2, inputs[0].Builds)
+	assert.Equal(t, 0.04, inputs[0].Duration)
+	assert.Equal(t, "failed", inputs[1].State)
+	assert.Equal(t, "pandoc failed", inputs[1].Error)
+	assert.Equal(t, 1, inputs[1].Failures)
+
+	buckets, sum, count := stats.histogram()
+	assert.Equal(t, []int{1, 2, 2, 2, 2, 3, 3, 3, 3}, buckets)
+	assert.InDelta(t, 2.12, sum, 1e-9)
+	assert.Equal(t, 3, count)
+}
+
+func Test_WriteMetrics(t *testing.T) {
+	col := &Collection{Name: "notes", Codex: &Codex{stats: NewBuildStats()}}
+	col.Codex.stats.finished(`we"ird.md`, time.Second, errors.New("oops"))
+
+	var buf strings.Builder
+	WriteMetrics(&buf, []*Collection{col})
+	metrics := buf.String()
+	assert.Contains(t, metrics, "# TYPE codex_build_duration_seconds histogram\n")
+	assert.Contains(t, metrics, `codex_build_duration_seconds_bucket{collection="notes",le="0.5"} 0`+"\n")
+	assert.Contains(t, metrics, `codex_build_duration_seconds_bucket{collection="notes",le="+Inf"} 1`+"\n")
+	assert.Contains(t, metrics, `codex_builds_total{collection="notes",source="we\"ird.md",result="failed"} 1`+"\n")
+	assert.Contains(t, metrics, `codex_build_failed{collection="notes",source="we\"ird.md"} 1`+"\n")
+	assert.Contains(t, metrics, `codex_websockets{collection="notes"} 0`+"\n")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// WrapperSelector matches elements that may wrap heads without carrying any
+// meaning for the semantic tree, eg pandoc's --section-divs output.
+const WrapperSelector = "section, div, article, main, header, footer"
+
+// Normalize prepares a DOM for Treeify by hoisting heads that are nested in
+// wrapper elements up to be immediate children of <body>, the main assumption
+// of treeify. For example:
+//    <section id="intro" class="level2">       <h2 id="intro" class="level2">
+//      <h2> Intro </h2>                ==>       Intro </h2>
+//      <p> ... </p>                            <p> ... </p>
+//    </section>
+//
+// Wrappers are dissolved such that their attributes survive: they are moved
+// to the wrapper's leading head if it starts with one, otherwise the content
+// preceding its first head stays wrapped in a copy of the wrapper. Heads in
+// other elements, eg blockquotes or list items, are left alone. Note that
+// the text nodes directly under a dissolved wrapper are not kept wrapped.
+func Normalize(doc *goquery.Document, rules HeadRules) {
+	ranks := rules.ranks(doc)
+	body := doc.Find("body").First()
+	for {
+		wrappers := body.ChildrenFiltered(WrapperSelector).FilterFunction(
+			func(i int, wrapper *goquery.Selection) bool {
+				return ranks.nestedHeads(wrapper).Length() > 0
+			})
+		if wrappers.Length() == 0 {
+			return
+		}
+		wrappers.Each(func(i int, wrapper *goquery.Selection) {
+			dissolve(wrapper, ranks)
+		})
+	}
+}
+
+// nestedHeads returns the heads under the selection that can be hoisted,
+// ie those with only wrappers between them and the selection.
+func (ranks headRanks) nestedHeads(sel *goquery.Selection) *goquery.Selection {
+	return ranks.heads(sel.Find("*")).FilterFunction(
+		func(i int, head *goquery.Selection) bool {
+			return head.ParentsUntilSelection(sel).Not(WrapperSelector).Length() == 0
+		})
+}
+
+// dissolve replaces a wrapper with its children, preserving its attributes.
+func dissolve(wrapper *goquery.Selection, ranks headRanks) {
+	children := wrapper.Children()
+	// the leading content is everything up to the first child that is or
+	// contains a head.
+	leadingEnd := children.Length()
+	children.EachWithBreak(func(i int, child *goquery.Selection) bool {
+		if _, ok := ranks[child.Get(0)]; ok || ranks.nestedHeads(child).Length() > 0 {
+			leadingEnd = i
+			return false
+		}
+		return true
+	})
+
+	if leadingEnd > 0 {
+		children.Slice(0, leadingEnd).WrapAllNode(shallowClone(wrapper.Get(0)))
+	} else if leadingEnd < children.Length() {
+		// a leading head, or a leading wrapper to be dissolved later
+		mergeAttrs(children.Get(0), wrapper.Get(0))
+	}
+	Unwrap(wrapper)
+}
+
+// mergeAttrs copies attributes of src to dst that dst doesn't have, and
+// merges their classes.
+func mergeAttrs(dst *html.Node, src *html.Node) {
+	existing := make(map[string]int)
+	for i, attr := range dst.Attr {
+		existing[attr.Key] = i
+	}
+	for _, attr := range src.Attr {
+		idx, ok := existing[attr.Key]
+		if !ok {
+			dst.Attr = append(dst.Attr, attr)
+		} else if attr.Key == "class" {
+			classes := strings.Fields(dst.Attr[idx].Val)
+			for _, cls := range strings.Fields(attr.Val) {
+				if !hasClass(dst, cls) {
+					classes = append(classes, cls)
+				}
+			}
+			dst.Attr[idx].Val = strings.Join(classes, " ")
+		}
+	}
+}
+
+func shallowClone(node *html.Node) *html.Node {
+	return &html.Node{
+		Type:      node.Type,
+		DataAtom:  node.DataAtom,
+		Data:      node.Data,
+		Namespace: node.Namespace,
+		Attr:      append([]html.Attribute{}, node.Attr...),
+	}
+}
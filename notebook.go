@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"html"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Notebook is a Jupyter notebook, nbformat 4, as far as codex cares.
+type Notebook struct {
+	Cells    []NotebookCell `json:"cells"`
+	Metadata struct {
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+	} `json:"metadata"`
+}
+
+type NotebookCell struct {
+	CellType       string           `json:"cell_type"` // markdown, code, or raw
+	Source         nbText           `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []NotebookOutput `json:"outputs"`
+}
+
+type NotebookOutput struct {
+	OutputType string            `json:"output_type"` // stream, execute_result, display_data, or error
+	Name       string            `json:"name"`        // stdout or stderr, for streams
+	Text       nbText            `json:"text"`
+	Data       map[string]nbText `json:"data"` // keyed by MIME type
+	Ename      string            `json:"ename"`
+	Evalue     string            `json:"evalue"`
+}
+
+// nbText is multiline text in notebooks, stored either as a string or as a
+// list of lines.
+type nbText string
+
+func (text *nbText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*text = nbText(strings.Join(lines, ""))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*text = nbText(str)
+	return nil
+}
+
+// language returns the programming language of the code cells.
+func (nb *Notebook) language() string {
+	if name := nb.Metadata.LanguageInfo.Name; name != "" {
+		return name
+	}
+	if lang := nb.Metadata.Kernelspec.Language; lang != "" {
+		return lang
+	}
+	return "python"
+}
+
+// LoadNotebook reads a Jupyter notebook as HTML. Markdown cells are converted
+// by pandoc, all at once, such that their headings are heads as in any
+// markdown input. Code cells, with their outputs, become code cells:
+//    <div class="codex-cell">
+//      <div class="codex-cell-in"> In [3]: </div>
+//      <pre class="sourceCode python"><code> ... </code></pre>
+//      <div class="codex-cell-output"> ... </div>
+//    </div>
+//
+// which Treeify turns into nodes of their own, see nodifyCell(). Raw cells are
+// skipped.
+func (cdx *Codex) LoadNotebook(path string) (*goquery.Document, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nb Notebook
+	if err := json.Unmarshal(contents, &nb); err != nil {
+		return nil, err
+	}
+
+	// code cells are placeholders in markdown, filled in after conversion.
+	// note: pandoc keeps ids of divs but not arbitrary attributes.
+	var markdown strings.Builder
+	codeCells := make(map[string]string)
+	for i, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			markdown.WriteString(string(cell.Source))
+		case "code":
+			id := fmt.Sprintf("codex-cell-%d", i)
+			codeCells[id] = codeCellHtml(cell, nb.language())
+			markdown.WriteString(fmt.Sprintf(`<div id="%s"></div>`, id))
+		default:
+			continue
+		}
+		markdown.WriteString("\n\n")
+	}
+
+	mdPath, err := TempSource(".md", []byte(markdown.String()))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(mdPath)
+	doc, err := cdx.runPandoc(mdPath)
+	if err != nil {
+		return nil, err
+	}
+	for id, cellHtml := range codeCells {
+		doc.Find(fmt.Sprintf(`div[id="%s"]`, id)).ReplaceWithHtml(cellHtml)
+	}
+	return doc, nil
+}
+
+// codeCellHtml returns the codex HTML of a code cell, see LoadNotebook().
+func codeCellHtml(cell NotebookCell, language string) string {
+	count := " "
+	if cell.ExecutionCount != nil {
+		count = fmt.Sprint(*cell.ExecutionCount)
+	}
+	var buf strings.Builder
+	buf.WriteString(`<div class="codex-cell">`)
+	buf.WriteString(fmt.Sprintf(`<div class="codex-cell-in">In [%s]:</div>`, count))
+	buf.WriteString(fmt.Sprintf(
+		`<pre class="sourceCode %s"><code>%s</code></pre>`,
+		html.EscapeString(language), html.EscapeString(string(cell.Source)),
+	))
+	for _, output := range cell.Outputs {
+		buf.WriteString(`<div class="codex-cell-output">`)
+		buf.WriteString(outputHtml(output))
+		buf.WriteString(`</div>`)
+	}
+	buf.WriteString(`</div>`)
+	return buf.String()
+}
+
+// outputHtml returns the HTML of a code cell output. Of rich outputs, the
+// first of images, HTML, and plain text is used.
+func outputHtml(output NotebookOutput) string {
+	switch output.OutputType {
+	case "stream":
+		return fmt.Sprintf(`<pre class="codex-cell-%s">%s</pre>`,
+			html.EscapeString(output.Name), html.EscapeString(string(output.Text)))
+	case "error":
+		return fmt.Sprintf(`<pre class="codex-cell-error">%s: %s</pre>`,
+			html.EscapeString(output.Ename), html.EscapeString(output.Evalue))
+	}
+	for _, mimeType := range []string{"image/png", "image/jpeg", "image/gif"} {
+		if data, ok := output.Data[mimeType]; ok {
+			// binary data is already base64 encoded, sans whitespace issues
+			encoded := strings.Join(strings.Fields(string(data)), "")
+			return fmt.Sprintf(`<img src="data:%s;base64,%s"/>`, mimeType, encoded)
+		}
+	}
+	if data, ok := output.Data["image/svg+xml"]; ok {
+		encoded := base64.StdEncoding.EncodeToString([]byte(data))
+		return fmt.Sprintf(`<img src="data:image/svg+xml;base64,%s"/>`, encoded)
+	}
+	if data, ok := output.Data["text/html"]; ok {
+		// eg data frames as tables; same treatment as HTML inputs
+		if doc, err := LoadHtml(string(data)); err == nil {
+			content := doc.Find("body")
+			content.Find(htmlStripSelector).Remove()
+			stripEventHandlers(content)
+			return InnerHtml(content)
+		}
+	}
+	if data, ok := output.Data["text/plain"]; ok {
+		return fmt.Sprintf(`<pre>%s</pre>`, html.EscapeString(string(data)))
+	}
+	return ""
+}
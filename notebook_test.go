@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Notebook_code_cells(t *testing.T) {
+	var nb Notebook
+	err := json.Unmarshal([]byte(`{
+		"cells": [
+			{"cell_type": "code", "execution_count": 1, "source": ["x = 1\n", "x < 2"],
+			 "outputs": [
+				{"output_type": "stream", "name": "stdout", "text": "hi\n"},
+				{"output_type": "execute_result", "data": {"text/plain": ["True"]}}
+			 ]},
+			{"cell_type": "code", "execution_count": null, "source": "raise", "outputs": [
+				{"output_type": "error", "ename": "RuntimeError", "evalue": "no"}
+			]}
+		],
+		"metadata": {"kernelspec": {"language": "julia"}}
+	}`), &nb)
+	assert.Nil(t, err)
+	assert.Equal(t, "julia", nb.language())
+	assert.Equal(t, nbText("x = 1\nx < 2"), nb.Cells[0].Source)
+
+	doc, _ := LoadHtml(`<h1>Analysis</h1><p>Intro</p>` +
+		codeCellHtml(nb.Cells[0], nb.language()) +
+		codeCellHtml(nb.Cells[1], nb.language()))
+	Treeify(doc, DefaultHeadRules)
+
+	cells := doc.Find(".codex-cell.node")
+	assert.Equal(t, 2, cells.Length())
+	first := cells.First()
+	assert.True(t, first.HasClass("node-depth-2"))
+	assert.Equal(t, []string{"Analysis", "In [1]:"}, HeadPath(first))
+	assert.Equal(t, "x = 1\nx < 2", first.Find(".node-body pre.julia > code").Text())
+	assert.Equal(t, "hi", selText(first.Find("pre.codex-cell-stdout")))
+	assert.Equal(t, "True", selText(first.Find(".codex-cell-output").Last()))
+	assert.Equal(t, "In [ ]:", HeadText(cells.Last()))
+	assert.Equal(t, "RuntimeError: no", selText(cells.Last().Find("pre.codex-cell-error")))
+}
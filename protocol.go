@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// ProtocolVersion is the version of the websocket protocol between codex and
+// its pages. Clients ask for it as the "codex.v<N>" subprotocol in the
+// handshake, and every message carries it, see Message. Clients that don't ask
+// for it, eg pages cached from before the protocol, are sent the legacy
+// protocol instead: the raw HTML of rebuilt articles and nothing else.
+//
+// New message types and payload fields can be added without a new version,
+// clients ignore what they don't know. Anything else needs a new version.
+const ProtocolVersion = 1
+
+var ProtocolName = fmt.Sprintf("codex.v%d", ProtocolVersion)
+
+// WebSocketWriteTimeout bounds writes to a websocket. Writes happen under
+// Collection.wsMu, a client that stops reading must not stall the others.
+const WebSocketWriteTimeout = 10 * time.Second
+
+// Message types, see Message.
+const (
+	// MsgHello is the first message on every connection. Its Seq is that of
+	// the last message sent to other clients, the next one is Seq+1.
+	MsgHello         = "hello"
+	MsgBuildStarted  = "build-started"
+	MsgBuildFinished = "build-finished" // payload: ArticlePayload
+	MsgBuildFailed   = "build-failed"   // payload: ErrorPayload
+	MsgReload        = "reload"         // the page is stale as a whole
+)
+
+// Message is the envelope of everything collections send their websocket
+// clients, as JSON, eg:
+//
+//	{"v": 1, "type": "build-finished", "source": "notes.md", "seq": 42, "payload": {"html": "<article ..."}}
+type Message struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	Source  string `json:"source,omitempty"` // path of the input, if any
+	// Seq numbers the messages of a collection in the order they are sent,
+	// such that clients can tell they missed some.
+	Seq     uint64      `json:"seq"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type ArticlePayload struct {
+	Html string `json:"html"` // the new <article>
+}
+
+type ErrorPayload struct {
+	Error string `json:"error"`
+}
+
+// wsClient is a connected websocket along with the protocol it speaks.
+type wsClient struct {
+	conn   *websocket.Conn
+	legacy bool // see ProtocolVersion
+}
+
+// newWsClient wraps a websocket, after its handshake, see upgrader.
+func newWsClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{conn: conn, legacy: conn.Subprotocol() != ProtocolName}
+}
+
+// send writes a message to the client, in the protocol it speaks. The given
+// JSON is the encoded message, shared by all clients.
+func (client *wsClient) send(msg Message, encoded []byte) error {
+	if !client.legacy {
+		return client.write(encoded)
+	}
+	if payload, ok := msg.Payload.(ArticlePayload); ok && msg.Type == MsgBuildFinished {
+		return client.write([]byte(payload.Html))
+	}
+	return nil // nothing else means anything to legacy clients
+}
+
+// sendJSON encodes and writes a message to the client, for messages to that
+// client alone, eg MsgHello.
+func (client *wsClient) sendJSON(msg Message) error {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return client.write(encoded)
+}
+
+// write writes a text frame within WebSocketWriteTimeout. A connection that
+// timed out is broken for good, and is dropped by the caller.
+func (client *wsClient) write(data []byte) error {
+	client.conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+	return client.conn.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_WebSocketProtocol(t *testing.T) {
+	col := &Collection{Codex: &Codex{}}
+	srv := httptest.NewServer(col.Handler())
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	readMessage := func(ws *websocket.Conn) Message {
+		var msg Message
+		_, data, err := ws.ReadMessage()
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(data, &msg))
+		return msg
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolName}}
+	client, _, err := dialer.Dial(url, nil)
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Equal(t, ProtocolName, client.Subprotocol())
+	assert.Equal(t, Message{Version: ProtocolVersion, Type: MsgHello}, readMessage(client))
+
+	legacy, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Nil(t, err)
+	defer legacy.Close()
+	assert.Eventually(t, func() bool { return col.WebSocketCount() == 2 }, 5*time.Second, time.Millisecond)
+
+	col.UpdateClients(Message{Type: MsgBuildStarted, Source: "a.md"})
+	col.UpdateClients(Message{Type: MsgBuildFinished, Source: "a.md", Payload: ArticlePayload{Html: "<article/>"}})
+
+	started := readMessage(client)
+	assert.Equal(t, MsgBuildStarted, started.Type)
+	assert.Equal(t, uint64(1), started.Seq)
+	finished := readMessage(client)
+	assert.Equal(t, uint64(2), finished.Seq)
+	assert.Equal(t, map[string]interface{}{"html": "<article/>"}, finished.Payload)
+
+	// legacy clients only get the html of articles
+	_, data, err := legacy.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, "<article/>", string(data))
+
+	// pages of a previous run are told to reload
+	stale, _, err := dialer.Dial(url+"?boot=stale", nil)
+	assert.Nil(t, err)
+	defer stale.Close()
+	assert.Equal(t, Message{Version: ProtocolVersion, Type: MsgHello, Seq: 2}, readMessage(stale))
+	assert.Equal(t, MsgReload, readMessage(stale).Type)
+}
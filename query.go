@@ -0,0 +1,445 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// queryFields are the fields terms can be restricted to, eg file:notes.md.
+// Anything else before a colon is part of a plain term, eg "10:30".
+var queryFields = map[string]bool{
+	"file": true, "tag": true, "heading": true, "date": true,
+}
+
+// Query is a parsed search query, see ParseQuery().
+type Query interface {
+	// match reports whether the entry matches the query, along with a score
+	// to rank matches by, higher is better.
+	match(entry *SearchEntry) (bool, int)
+	// highlights returns what to highlight in the text of a matching entry.
+	highlights(entry *SearchEntry) []string
+}
+
+// ParseQuery parses a search query. Terms match case-insensitively anywhere
+// in the text of a node or the headings above it, and are combined with AND
+// unless joined by OR. Terms can be:
+//    word  "a phrase"  /a regex/          plain terms
+//    heading:word  heading:"a phrase"    in the heading of the node, or above it
+//    file:notes  file:/\.org$/           in the path of the input
+//    tag:project-x                       tagged, see Tagger, # or @ is optional
+//    date:2021-11  date:2021-11-01..2021-11-15  date:2021-11..  date:..2021-11
+//                                        dated, see HeadPathDate()
+//    -term  NOT term  (a OR b) c         negation and grouping
+func ParseQuery(query string) (Query, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	parser := &queryParser{tokens: tokens}
+	parsed, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, errors.New(fmt.Sprintf("Unexpected %q in query", parser.tokens[parser.pos].text))
+	}
+	return parsed, nil
+}
+
+type queryTokenKind int
+
+const (
+	tokenTerm queryTokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	text  string // as given, for errors
+	field string // for terms, empty for plain terms
+	value string
+	regex bool // value is a regex, eg /a.*b/
+}
+
+// tokenizeQuery splits a query into terms, operators, and parentheses.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	tokens := []queryToken{}
+	pos := 0
+
+	// readUntil returns the text up to the given delimiter, which is skipped,
+	// or to the end. Backslashes escape delimiters.
+	readUntil := func(delim rune) string {
+		var buf strings.Builder
+		for ; pos < len(runes) && runes[pos] != delim; pos++ {
+			if runes[pos] == '\\' && pos+1 < len(runes) && runes[pos+1] == delim {
+				pos++
+			}
+			buf.WriteRune(runes[pos])
+		}
+		pos++ // the delimiter
+		return buf.String()
+	}
+	isDelim := func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	}
+
+	for pos < len(runes) {
+		start := pos
+		switch r := runes[pos]; {
+		case unicode.IsSpace(r):
+			pos++
+			continue
+		case r == '(':
+			pos++
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "("})
+			continue
+		case r == ')':
+			pos++
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")"})
+			continue
+		case r == '-' && pos+1 < len(runes) && !isDelim(runes[pos+1]):
+			pos++
+			tokens = append(tokens, queryToken{kind: tokenNot, text: "-"})
+			continue
+		}
+
+		token := queryToken{kind: tokenTerm}
+		// field prefix, if any
+		for end := pos; end < len(runes) && !isDelim(runes[end]); end++ {
+			if runes[end] == ':' {
+				if field := strings.ToLower(string(runes[pos:end])); queryFields[field] {
+					token.field = field
+					pos = end + 1
+				}
+				break
+			}
+		}
+		switch {
+		case pos < len(runes) && runes[pos] == '"':
+			pos++
+			token.value = readUntil('"')
+		case pos < len(runes) && runes[pos] == '/':
+			pos++
+			token.value = readUntil('/')
+			token.regex = true
+		default:
+			end := pos
+			for end < len(runes) && !isDelim(runes[end]) {
+				end++
+			}
+			token.value = string(runes[pos:end])
+			pos = end
+		}
+		if pos > len(runes) {
+			pos = len(runes)
+		}
+		token.text = string(runes[start:pos])
+
+		if token.field == "" && !token.regex && runes[start] != '"' {
+			switch token.value {
+			case "AND":
+				token.kind = tokenAnd
+			case "OR":
+				token.kind = tokenOr
+			case "NOT":
+				token.kind = tokenNot
+			}
+		}
+		if token.kind == tokenTerm && token.value == "" {
+			return nil, errors.New(fmt.Sprintf("Empty term in query: %q", token.text))
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser of query tokens:
+//    or    := and ("OR" and)*
+//    and   := unary ("AND"? unary)*
+//    unary := ("NOT" | "-") unary | "(" or ")" | term
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (parser *queryParser) peek() (queryToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return queryToken{}, false
+	}
+	return parser.tokens[parser.pos], true
+}
+
+func (parser *queryParser) parseOr() (Query, error) {
+	first, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	queries := orQuery{first}
+	for token, ok := parser.peek(); ok && token.kind == tokenOr; token, ok = parser.peek() {
+		parser.pos++
+		next, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, next)
+	}
+	if len(queries) == 1 {
+		return first, nil
+	}
+	return queries, nil
+}
+
+func (parser *queryParser) parseAnd() (Query, error) {
+	queries := andQuery{}
+	for token, ok := parser.peek(); ok && token.kind != tokenOr && token.kind != tokenClose; token, ok = parser.peek() {
+		if token.kind == tokenAnd {
+			parser.pos++
+			continue
+		}
+		next, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, next)
+	}
+	if len(queries) == 0 {
+		return nil, errors.New("Expected a term in query")
+	}
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return queries, nil
+}
+
+func (parser *queryParser) parseUnary() (Query, error) {
+	token, ok := parser.peek()
+	if !ok {
+		return nil, errors.New("Expected a term in query")
+	}
+	parser.pos++
+	switch token.kind {
+	case tokenNot:
+		negated, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{negated}, nil
+	case tokenOpen:
+		grouped, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := parser.peek(); !ok || closing.kind != tokenClose {
+			return nil, errors.New("Missing ) in query")
+		}
+		parser.pos++
+		return grouped, nil
+	case tokenTerm:
+		return newTermQuery(token)
+	}
+	return nil, errors.New(fmt.Sprintf("Unexpected %q in query", token.text))
+}
+
+type andQuery []Query
+
+func (query andQuery) match(entry *SearchEntry) (bool, int) {
+	total := 0
+	for _, sub := range query {
+		ok, score := sub.match(entry)
+		if !ok {
+			return false, 0
+		}
+		total += score
+	}
+	return true, total
+}
+
+func (query andQuery) highlights(entry *SearchEntry) []string {
+	var all []string
+	for _, sub := range query {
+		all = append(all, sub.highlights(entry)...)
+	}
+	return all
+}
+
+type orQuery []Query
+
+func (query orQuery) match(entry *SearchEntry) (bool, int) {
+	matched, best := false, 0
+	for _, sub := range query {
+		if ok, score := sub.match(entry); ok {
+			matched = true
+			if score > best {
+				best = score
+			}
+		}
+	}
+	return matched, best
+}
+
+func (query orQuery) highlights(entry *SearchEntry) []string {
+	var all []string
+	for _, sub := range query {
+		if ok, _ := sub.match(entry); ok {
+			all = append(all, sub.highlights(entry)...)
+		}
+	}
+	return all
+}
+
+type notQuery struct {
+	negated Query
+}
+
+func (query notQuery) match(entry *SearchEntry) (bool, int) {
+	ok, _ := query.negated.match(entry)
+	return !ok, 0
+}
+
+func (query notQuery) highlights(entry *SearchEntry) []string {
+	return nil
+}
+
+// Scores of term matches, by where they match.
+const (
+	scoreHead      = 3 // the node's own heading
+	scoreAncestors = 2 // a heading above the node
+	scoreText      = 1 // the text of the node
+)
+
+// termQuery is a single, possibly field restricted, term.
+type termQuery struct {
+	field string
+	value string // lower case, for plain terms and phrases
+	regex *regexp.Regexp
+}
+
+func newTermQuery(token queryToken) (Query, error) {
+	query := termQuery{field: token.field, value: strings.ToLower(token.value)}
+	if token.regex {
+		regex, err := regexp.Compile("(?i)" + token.value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid regex in query: %s", err))
+		}
+		query.regex = regex
+	}
+	if query.field == "date" {
+		return newDateQuery(query.value)
+	}
+	return query, nil
+}
+
+func (query termQuery) matches(text string) bool {
+	if query.regex != nil {
+		return query.regex.MatchString(text)
+	}
+	return strings.Contains(strings.ToLower(text), query.value)
+}
+
+func (query termQuery) match(entry *SearchEntry) (bool, int) {
+	headings := func() (bool, int) {
+		if query.matches(entry.Head) {
+			return true, scoreHead
+		}
+		for _, head := range entry.Heads {
+			if query.matches(head) {
+				return true, scoreAncestors
+			}
+		}
+		return false, 0
+	}
+
+	switch query.field {
+	case "heading":
+		return headings()
+	case "file":
+		return query.matches(entry.Source), 0
+	case "tag":
+		for _, tag := range entry.Tags {
+			if query.regex != nil && query.regex.MatchString(tag) ||
+				query.regex == nil && strings.TrimLeft(tag, "#@") == strings.TrimLeft(query.value, "#@") {
+				return true, 0
+			}
+		}
+		return false, 0
+	}
+	if ok, score := headings(); ok {
+		return true, score
+	}
+	return query.matches(entry.Text), scoreText
+}
+
+// highlights returns the term, or for regexes what they match where the term
+// is matched, see match(): headings for heading:, headings and text otherwise.
+func (query termQuery) highlights(entry *SearchEntry) []string {
+	var texts []string
+	switch query.field {
+	case "":
+		texts = append([]string{entry.Head, entry.Text}, entry.Heads...)
+	case "heading":
+		texts = append([]string{entry.Head}, entry.Heads...)
+	default:
+		return nil
+	}
+	if query.regex == nil {
+		return []string{query.value}
+	}
+	var found []string
+	for _, text := range texts {
+		found = append(found, query.regex.FindAllString(text, 10-len(found))...)
+		if len(found) >= 10 {
+			break
+		}
+	}
+	return found
+}
+
+// dateQuery matches entries dated within a range of ISO dates, eg
+// 2021-11-01..2021-11-15, either end of which is optional and may be partial,
+// eg 2021-11.. is anything since November 2021. A single date is a range from
+// and to itself, eg 2021-11 is all of November 2021.
+type dateQuery struct {
+	from string
+	to   string
+}
+
+// dateBoundRegex matches the ends of date ranges, eg 2021, 2021-11, or
+// 2021-11-30.
+var dateBoundRegex = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+func newDateQuery(value string) (Query, error) {
+	if value == "" || value == ".." {
+		return nil, errors.New("Empty date range in query")
+	}
+	query := dateQuery{from: value, to: value}
+	if parts := strings.SplitN(value, "..", 2); len(parts) == 2 {
+		query = dateQuery{from: parts[0], to: parts[1]}
+	}
+	for _, bound := range []string{query.from, query.to} {
+		if bound != "" && !dateBoundRegex.MatchString(bound) {
+			return nil, errors.New(fmt.Sprintf("Invalid date %q in query, expected eg 2021-11-30", bound))
+		}
+	}
+	return query, nil
+}
+
+func (query dateQuery) match(entry *SearchEntry) (bool, int) {
+	if entry.Date == "" {
+		return false, 0
+	}
+	afterFrom := query.from == "" || entry.Date >= query.from
+	beforeTo := query.to == "" || entry.Date <= query.to || strings.HasPrefix(entry.Date, query.to)
+	return afterFrom && beforeTo, 0
+}
+
+func (query dateQuery) highlights(entry *SearchEntry) []string {
+	return nil
+}
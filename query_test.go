@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ParseQuery(t *testing.T) {
+	entry := &SearchEntry{
+		Source: "work/notes.md",
+		Head:   "Standup at 10:30",
+		Heads:  []string{"2021-11-30 Tue", "Meetings"},
+		Text:   "Discussed the release plan for v1.2 with @alice.",
+		Tags:   []string{"@alice", "#team"},
+		Date:   "2021-11-30",
+	}
+	cases := []struct {
+		query string
+		match bool
+		score int
+	}{
+		{"standup", true, scoreHead},
+		{"STANDUP meetings", true, scoreHead + scoreAncestors},
+		{"release", true, scoreText},
+		{"10:30", true, scoreHead}, // not a field
+		{`"release plan"`, true, scoreText},
+		{`"plan release"`, false, 0},
+		{`/v\d+\.\d+/`, true, scoreText},
+		{"heading:meetings", true, scoreAncestors},
+		{"heading:release", false, 0},
+		{`heading:"at 10:30"`, true, scoreHead},
+		{"file:work", true, 0},
+		{`file:/\.org$/`, false, 0},
+		{"tag:alice", true, 0},
+		{"tag:#team", true, 0},
+		{"tag:ali", false, 0},
+		{"date:2021-11", true, 0},
+		{"date:2021-11-01..2021-11-15", false, 0},
+		{"date:2021-11-15..", true, 0},
+		{"date:..2021-11", true, 0},
+		{"date:..2021-10", false, 0},
+		{"standup OR missing", true, scoreHead},
+		{"missing OR release", true, scoreText},
+		{"standup missing", false, 0},
+		{"standup AND -release", false, 0},
+		{"standup NOT missing", true, scoreHead},
+		{"(missing OR release) standup", true, scoreText + scoreHead},
+	}
+	for _, c := range cases {
+		query, err := ParseQuery(c.query)
+		assert.Nil(t, err, c.query)
+		match, score := query.match(entry)
+		assert.Equal(t, c.match, match, c.query)
+		if c.match {
+			assert.Equal(t, c.score, score, c.query)
+		}
+	}
+
+	query, _ := ParseQuery(`/v\d\.\d/ "release plan" -bob file:notes`)
+	assert.Equal(t, []string{"v1.2", "release plan"}, query.highlights(entry))
+	// regexes are highlighted where they match
+	query, _ = ParseQuery(`heading:/\d+:\d+/ /\d+:\d+/`)
+	assert.Equal(t, []string{"10:30", "10:30"}, query.highlights(entry))
+	query, _ = ParseQuery(`heading:/tue|plan/`)
+	assert.Equal(t, []string{"Tue"}, query.highlights(entry))
+
+	for _, invalid := range []string{
+		"(standup", "standup)", "OR", "NOT", "file:", `/(/`, "date:..",
+		"date:2021-1", "date:21-11-30", "date:2021-11-30..x", "date:last..", "date:2021-11-30T10",
+	} {
+		_, err := ParseQuery(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// SanitizePolicy is an allowlist of the elements and attributes of input HTML
+// that make it to clients, see Sanitize().
+type SanitizePolicy struct {
+	Elements map[string]bool // lowercase tag names
+	Attrs    map[string]bool // lowercase attribute names, on any element
+}
+
+// sanitizeDropElements are removed along with their contents under any
+// policy; other elements not in a policy are replaced by their contents.
+var sanitizeDropElements = wordSet(`
+	script style iframe frame frameset object embed applet template noscript
+	base meta link title select textarea
+`)
+
+// sanitizeUrlAttrs are attributes whose values are URLs, see safeUrl().
+var sanitizeUrlAttrs = wordSet(`href src cite poster`)
+
+var strictElements = `
+	p div span br hr wbr h1 h2 h3 h4 h5 h6 blockquote pre code kbd samp var
+	em strong b i u s del ins mark small sub sup abbr cite q dfn time
+	a img figure figcaption ul ol li dl dt dd input details summary
+	table thead tbody tfoot tr th td caption colgroup col
+	section article header footer main ruby rt rp
+`
+
+var strictAttrs = `
+	id class title lang dir href src alt width height align
+	colspan rowspan headers scope span start reversed type value
+	checked disabled datetime cite open role
+`
+
+// StrictPolicy allows document structure and text formatting only: no inline
+// styles, media, SVG, or MathML.
+var StrictPolicy = &SanitizePolicy{
+	Elements: wordSet(strictElements),
+	Attrs:    wordSet(strictAttrs),
+}
+
+// RelaxedPolicy additionally allows inline styles, audio and video, and
+// inline SVG and MathML.
+var RelaxedPolicy = &SanitizePolicy{
+	Elements: wordSet(strictElements + `
+		video audio source track picture
+		svg g path circle ellipse line polyline polygon rect text tspan defs
+		desc lineargradient radialgradient stop clippath mask pattern marker
+		symbol image
+		math mi mn mo ms mtext mrow msub msup msubsup mfrac msqrt mroot mtable
+		mtr mtd mover munder munderover mspace mstyle mpadded mphantom
+		menclose semantics annotation
+	`),
+	Attrs: wordSet(strictAttrs + `
+		style controls loop muted poster kind srclang label
+		xmlns viewbox preserveaspectratio d transform x y x1 y1 x2 y2 cx cy r
+		rx ry points fill stroke stroke-width stroke-linecap stroke-linejoin
+		stroke-dasharray opacity fill-opacity stroke-opacity font-size
+		font-family font-weight text-anchor dominant-baseline offset
+		stop-color stop-opacity gradientunits gradienttransform clip-path
+		marker-end marker-start refx refy markerwidth markerheight orient
+		display mathvariant stretchy fence separator lspace rspace accent
+		accentunder columnalign linethickness encoding
+	`),
+}
+
+// SanitizePolicies are the policies available by name, "off" means no
+// sanitization at all.
+var SanitizePolicies = map[string]*SanitizePolicy{
+	"strict":  StrictPolicy,
+	"relaxed": RelaxedPolicy,
+	"off":     nil,
+}
+
+// LookupSanitizePolicy returns the policy with the given name, empty means
+// strict.
+func LookupSanitizePolicy(name string) (*SanitizePolicy, error) {
+	if name == "" {
+		return StrictPolicy, nil
+	}
+	policy, ok := SanitizePolicies[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown sanitize policy: %s", name))
+	}
+	return policy, nil
+}
+
+// DefaultSanitizePolicy returns the name of the policy to use when serving
+// on the given address: inputs are trusted as much as the audience is.
+func DefaultSanitizePolicy(addr string) string {
+	if IsLoopback(addr) {
+		return "relaxed"
+	}
+	return "strict"
+}
+
+// Sanitize removes everything not allowed by the policy from the <body> of
+// the given doc, in place. A nil policy leaves the doc alone. Regardless of
+// policy:
+//  - scripts, styles, frames, plugins, and comments are removed entirely,
+//  - event handler attributes, eg onerror, are never allowed, while data-*
+//    and aria-* attributes always are,
+//  - URLs must be relative or use http(s), mailto, or tel; images may also
+//    use data URLs,
+//  - inputs other than checkboxes, eg pandoc task lists, are removed.
+func (policy *SanitizePolicy) Sanitize(doc *goquery.Document) {
+	if policy == nil {
+		return
+	}
+	for _, body := range doc.Find("body").Nodes {
+		policy.sanitizeChildren(body)
+	}
+}
+
+func (policy *SanitizePolicy) sanitizeChildren(parent *html.Node) {
+	// note: nodes are removed and moved along the way, don't lose track of
+	// siblings.
+	for child := parent.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.CommentNode:
+			parent.RemoveChild(child)
+		case html.ElementNode:
+			tag := strings.ToLower(child.Data)
+			switch {
+			case sanitizeDropElements[tag] || (tag == "input" && !isCheckbox(child)):
+				parent.RemoveChild(child)
+			case !policy.Elements[tag]:
+				// unwrap, and sanitize the unwrapped children next
+				if child.FirstChild != nil {
+					next = child.FirstChild
+				}
+				for grandchild := child.FirstChild; grandchild != nil; grandchild = child.FirstChild {
+					child.RemoveChild(grandchild)
+					parent.InsertBefore(grandchild, child)
+				}
+				parent.RemoveChild(child)
+			default:
+				policy.sanitizeAttrs(child)
+				policy.sanitizeChildren(child)
+			}
+		}
+		child = next
+	}
+}
+
+func (policy *SanitizePolicy) sanitizeAttrs(node *html.Node) {
+	var attrs []html.Attribute
+	for _, attr := range node.Attr {
+		key := strings.ToLower(attr.Key)
+		allowed := policy.Attrs[key] ||
+			strings.HasPrefix(key, "data-") || strings.HasPrefix(key, "aria-")
+		if !allowed || strings.HasPrefix(key, "on") {
+			continue
+		}
+		if sanitizeUrlAttrs[key] && !safeUrl(attr.Val, node.Data == "img" || node.Data == "image") {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	node.Attr = attrs
+}
+
+// safeUrl reports whether a URL is relative or uses an allowed scheme. Data
+// URLs are only allowed for images.
+func safeUrl(value string, image bool) bool {
+	// browsers ignore whitespace and control characters in schemes, eg
+	// "java\tscript:"
+	clean := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value))
+	end := strings.IndexAny(clean, ":/?#")
+	if end == -1 || clean[end] != ':' {
+		return true // no scheme, relative
+	}
+	switch clean[:end] {
+	case "http", "https", "mailto", "tel":
+		return true
+	case "data":
+		return image && strings.HasPrefix(clean, "data:image/")
+	default:
+		return false
+	}
+}
+
+func isCheckbox(node *html.Node) bool {
+	for _, attr := range node.Attr {
+		if strings.ToLower(attr.Key) == "type" {
+			return strings.ToLower(attr.Val) == "checkbox"
+		}
+	}
+	return false
+}
+
+// wordSet returns the set of whitespace separated words in the given string.
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Sanitize_strict(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1 id="title" onclick="alert(1)">Title</h1>
+		<script>alert(2)</script>
+		<!-- a comment -->
+		<p style="color: red">Hello <font color="red">World</font>
+		  <img src="x.png" onerror="alert(3)"> <img src="data:image/png;base64,AAAA">
+		  <a href="java	script:alert(4)">bad</a> <a href="https://example.com" data-pos="a.md@1:1-1:3">good</a>
+		</p>
+		<ul><li><input type="checkbox" checked disabled> done</li><li><input type="text"> text</li></ul>
+		<svg><circle r="1"/></svg>
+	`)
+	StrictPolicy.Sanitize(doc)
+
+	assert.Equal(t, "title", doc.Find("h1").AttrOr("id", ""))
+	assert.Equal(t, 0, selCount(doc.Selection, "script, font, svg, circle, [onclick], [onerror], [style]"))
+	assert.Equal(t, "Hello World", selText(doc.Find("p"))[:11])
+	assert.Equal(t, 2, selCount(doc.Selection, "img[src]"))
+	assert.Equal(t, "", doc.Find("a").First().AttrOr("href", ""))
+	assert.Equal(t, "https://example.com", doc.Find("a").Last().AttrOr("href", ""))
+	assert.Equal(t, "a.md@1:1-1:3", doc.Find("a").Last().AttrOr("data-pos", ""))
+	assert.Equal(t, 1, selCount(doc.Selection, `input[type="checkbox"]`))
+	assert.NotContains(t, InnerHtml(doc.Find("body")), "comment")
+}
+
+func Test_Sanitize_relaxed(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<p style="color: red">Hi <img src="data:text/html,bad"></p>
+		<svg viewBox="0 0 2 2"><circle r="1" onload="alert(1)"/><script>alert(2)</script></svg>
+	`)
+	RelaxedPolicy.Sanitize(doc)
+
+	assert.Equal(t, "color: red", doc.Find("p").AttrOr("style", ""))
+	assert.Equal(t, "", doc.Find("img").AttrOr("src", ""))
+	assert.Equal(t, "0 0 2 2", doc.Find("svg").AttrOr("viewBox", ""))
+	assert.Equal(t, 1, selCount(doc.Selection, "circle"))
+	assert.Equal(t, 0, selCount(doc.Selection, "script, [onload]"))
+}
+
+func Test_DefaultSanitizePolicy(t *testing.T) {
+	assert.Equal(t, "relaxed", DefaultSanitizePolicy("127.0.0.1:8000"))
+	assert.Equal(t, "relaxed", DefaultSanitizePolicy("localhost:8000"))
+	assert.Equal(t, "relaxed", DefaultSanitizePolicy("[::1]:8000"))
+	assert.Equal(t, "strict", DefaultSanitizePolicy(":8000"))
+	assert.Equal(t, "strict", DefaultSanitizePolicy("0.0.0.0:8000"))
+}
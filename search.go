@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"sort"
+	"strings"
+)
+
+const (
+	SearchMaxHits = 100 // default maximum number of node hits per search
+)
+
+// SearchEntry is a node as seen by search, see SearchIndex() and ParseQuery().
+type SearchEntry struct {
+	Id     string
+	Source string
+	Head   string   // the node's own head, empty if headless
+	Heads  []string // heads of its ancestors, outermost first
+	Text   string   // the node's body, without its child nodes
+	Tags   []string // lowercased, in its head, body, or ancestor heads
+	Date   string   // eg 2021-11-30, see HeadPathDate()
+}
+
+// SearchIndex returns the search entries of all nodes in an <article>, in
+// document order.
+func SearchIndex(article *goquery.Selection, formats []string) []SearchEntry {
+	source := article.AttrOr("codex-source", "")
+	entries := []SearchEntry{}
+	article.Find(".node").Each(func(i int, node *goquery.Selection) {
+		path := HeadPath(node)
+		entry := SearchEntry{
+			Id:     node.AttrOr("id", ""),
+			Source: source,
+			Heads:  path,
+		}
+		if !node.HasClass("headless") && len(path) > 0 {
+			entry.Head, entry.Heads = path[len(path)-1], path[:len(path)-1]
+		}
+		if date, ok := HeadPathDate(path, formats); ok {
+			entry.Date = date.Format("2006-01-02")
+		}
+
+		body := node.ChildrenFiltered(".node-body").Clone()
+		body.Find(".node").Remove()
+		entry.Text = strings.TrimSpace(PlainText(body))
+
+		seen := make(map[string]bool)
+		addTags := func(sel *goquery.Selection) {
+			sel.Find("span.codex-tag").Each(func(i int, span *goquery.Selection) {
+				tag := strings.ToLower(span.AttrOr("codex-tag", ""))
+				if !seen[tag] {
+					seen[tag] = true
+					entry.Tags = append(entry.Tags, tag)
+				}
+			})
+		}
+		addTags(body)
+		addTags(node.ChildrenFiltered(".node-head"))
+		node.ParentsFiltered(".node").Each(func(i int, ancestor *goquery.Selection) {
+			addTags(ancestor.ChildrenFiltered(".node-head"))
+		})
+		entries = append(entries, entry)
+	})
+	return entries
+}
+
+// SearchHit is a single node matching a search.
+type SearchHit struct {
+	Id    string   `json:"id"`
+	Head  []string `json:"head"` // see HeadPath()
+	Text  string   `json:"text"`
+	Score int      `json:"score"` // higher is better, see ParseQuery()
+	// Matches are the matched strings, for highlighting.
+	Matches []string `json:"matches,omitempty"`
+}
+
+// SearchResult groups search hits by article.
+type SearchResult struct {
+	Source string      `json:"source"`
+	Meta   Metadata    `json:"meta"`
+	Hits   []SearchHit `json:"hits,omitempty"`
+}
+
+// Search finds nodes matching the given query, see ParseQuery(), in articles
+// whose front matter matches all the given filters, eg
+// {"tags": "work", "status": "draft"}. Hits are ranked by score within each
+// article, and articles by their best hit, up to a total of limit hits. An
+// empty query matches no nodes but still lists matching articles.
+func (cdx *Codex) Search(query string, filters map[string]string, limit int) ([]SearchResult, error) {
+	var parsed Query
+	if strings.TrimSpace(query) != "" {
+		var err error
+		if parsed, err = ParseQuery(query); err != nil {
+			return nil, err
+		}
+	}
+
+	cdx.mu.RLock()
+	defer cdx.mu.RUnlock()
+
+	results := []SearchResult{}
+	best := make(map[string]int)
+	cdx.HtmlDoc.Find("article[codex-source]").Each(func(i int, article *goquery.Selection) {
+		source := article.AttrOr("codex-source", "")
+		meta := cdx.Meta[source]
+		for key, value := range filters {
+			if !meta.Matches(key, value) {
+				return
+			}
+		}
+		result := SearchResult{Source: source, Meta: meta}
+		if parsed == nil {
+			results = append(results, result)
+			return
+		}
+		for idx := range cdx.SearchIndex[source] {
+			entry := &cdx.SearchIndex[source][idx]
+			ok, score := parsed.match(entry)
+			if !ok {
+				continue
+			}
+			head := entry.Heads
+			if entry.Head != "" {
+				head = append(append([]string{}, entry.Heads...), entry.Head)
+			}
+			result.Hits = append(result.Hits, SearchHit{
+				Id:      entry.Id,
+				Head:    head,
+				Text:    entry.Text,
+				Score:   score,
+				Matches: parsed.highlights(entry),
+			})
+			if score > best[source] {
+				best[source] = score
+			}
+		}
+		if len(result.Hits) > 0 {
+			sort.SliceStable(result.Hits, func(i, j int) bool {
+				return result.Hits[i].Score > result.Hits[j].Score
+			})
+			results = append(results, result)
+		}
+	})
+	if parsed == nil {
+		return results, nil
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return best[results[i].Source] > best[results[j].Source]
+	})
+	if limit <= 0 {
+		return []SearchResult{}, nil
+	}
+	nhits := 0
+	for idx := range results {
+		if nhits+len(results[idx].Hits) > limit {
+			results[idx].Hits = results[idx].Hits[:limit-nhits]
+		}
+		nhits += len(results[idx].Hits)
+		if nhits >= limit {
+			results = results[:idx+1]
+			break
+		}
+	}
+	return results, nil
+}
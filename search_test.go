@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+// searchCodex returns a codex of dir/work.html and dir/home.html, titled
+// after their names.
+func searchCodex(dir string) *Codex {
+	return fixtureCodex(dir, Config{TagPatterns: DefaultTagPatterns},
+		fixtureInput{Name: "work.html", Html: `
+			<title>work</title>
+			<h1>2021-11-30 Standup</h1> <p>The release is late.</p>
+			<h2>Retro #team</h2> <p>Talk about the release plan.</p>
+		`},
+		fixtureInput{Name: "home.html", Html: `
+			<title>home</title>
+			<h1>Groceries</h1> <p>Release the hounds.</p>
+		`},
+	)
+}
+
+func Test_SearchIndex(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work.html")
+	cdx := searchCodex(dir)
+	entries := cdx.SearchIndex[work]
+	var retro SearchEntry
+	for _, entry := range entries {
+		if entry.Head == "Retro #team" {
+			retro = entry
+		}
+	}
+	assert.Equal(t, work, retro.Source)
+	assert.Equal(t, []string{"2021-11-30 Standup"}, retro.Heads)
+	assert.Equal(t, []string{"#team"}, retro.Tags)
+	assert.Equal(t, "2021-11-30", retro.Date)
+	// child nodes are entries of their own
+	for _, entry := range entries {
+		if entry.Head == "2021-11-30 Standup" {
+			assert.NotContains(t, entry.Text, "release plan")
+		}
+	}
+}
+
+func Test_Search(t *testing.T) {
+	dir := t.TempDir()
+	work, home := filepath.Join(dir, "work.html"), filepath.Join(dir, "home.html")
+	cdx := searchCodex(dir)
+
+	results, err := cdx.Search("release", nil, SearchMaxHits)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.ElementsMatch(t, []string{work, home}, []string{results[0].Source, results[1].Source})
+
+	// heading matches rank first, articles by their best hit
+	results, err = cdx.Search("retro OR hounds", nil, SearchMaxHits)
+	assert.Nil(t, err)
+	assert.Equal(t, work, results[0].Source)
+	assert.Equal(t, []string{"2021-11-30 Standup", "Retro #team"}, results[0].Hits[0].Head)
+	assert.Equal(t, scoreHead, results[0].Hits[0].Score)
+
+	results, _ = cdx.Search("release file:home", nil, SearchMaxHits)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, home, results[0].Source)
+
+	results, _ = cdx.Search(`tag:team "release plan"`, nil, SearchMaxHits)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, []string{"release plan"}, results[0].Hits[0].Matches)
+
+	results, _ = cdx.Search("release", map[string]string{"title": "home"}, SearchMaxHits)
+	assert.Equal(t, 1, len(results))
+
+	results, _ = cdx.Search("release", nil, 1)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, 1, len(results[0].Hits))
+
+	results, _ = cdx.Search("", nil, SearchMaxHits)
+	assert.Equal(t, 2, len(results))
+	assert.Nil(t, results[0].Hits)
+
+	_, err = cdx.Search("(release", nil, SearchMaxHits)
+	assert.NotNil(t, err)
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/websocket"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -13,10 +14,23 @@ const (
 	debounceWait = 200 * time.Millisecond
 )
 
+// ContentSecurityPolicy limits what pages served by codex can load and run,
+// a second line of defense after sanitization of inputs, see sanitize.go.
+// Scripts only come from codex itself and the exact URLs of its dependencies,
+// see index.go, not from anything else on their CDNs. Styles may be inline
+// since MathJax injects its own, its fonts come from next to its script.
+const ContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' " + jqueryScript + " " + markScript + " " + mathjaxScript + "; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+	"font-src 'self' data: https://fonts.gstatic.com https://cdn.jsdelivr.net/npm/mathjax@3/es5/output/; " +
+	"img-src * data:; media-src *; connect-src 'self' ws: wss:; " +
+	"object-src 'none'; base-uri 'none'; frame-ancestors 'none'; form-action 'self'"
+
 var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		Subprotocols:    []string{ProtocolName},
 	}
 )
 
@@ -26,144 +40,116 @@ var (
 //  - serving contents and static files over HTTP
 //  - managing WebSocket connections for incremental updates.
 //
-// Concurrency model:
+// A server hosts one or more collections, each an independent Codex, see
+// Collection. A server with a single unnamed collection serves it at /,
+// otherwise each collection is served under /c/<name>/ and / lists them.
+//
+// Concurrency model, per collection:
 //  1. The first build on codex boot consumes all inputs in parallel, upto a
 //     maximum concurrency level, see Codex.BuildAll()
 //  2. Each subsequent build is triggered by a single file change, incremental
 //     builds are always serialized; no two updates happen concurrently.
 type Server struct {
-	Codex *Codex
-	Addr  string // whatever http.Listen() accepts
+	Addr   string // whatever http.Listen() accepts
+	Config Config // of all collections
 
-	watcher *fsnotify.Watcher
-	status  map[string]string
+	Collections []*Collection
 
-	updates chan *Document
-	builds  chan *Document
-
-	websockets []*websocket.Conn
+	// Auth guards all endpoints, see auth.go.
+	Auth Auth
+	// TLSCert and TLSKey are the PEM files to serve HTTPS with, if set.
+	TLSCert string
+	TLSKey  string
 }
 
-func NewServer(paths []string, addr string) *Server {
-	cdx, err := NewCodex(paths)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	watcher, err := createWatcher(cdx.Inputs)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return &Server{
-		Codex:   cdx,
-		Addr:    addr,
-		watcher: watcher,
-		status:  make(map[string]string),
-		updates: make(chan *Document),
-		builds:  make(chan *Document),
-	}
+func NewServer(addr string, conf Config) *Server {
+	return &Server{Addr: addr, Config: conf}
 }
 
-func createWatcher(codocs map[string]*Document) (*fsnotify.Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+// AddCollection builds a new collection from the given inputs. The name of a
+// server's only collection may be empty, see Server.
+func (srv *Server) AddCollection(name string, paths []string) error {
+	if name != "" && !collectionNameRegex.MatchString(name) {
+		return errors.New(fmt.Sprintf("Invalid collection name: %q", name))
 	}
-	for _, codoc := range codocs {
-		if err := watcher.Add(codoc.Path); err != nil {
-			return nil, err
+	for _, col := range srv.Collections {
+		if col.Name == name || col.Name == "" || name == "" {
+			return errors.New(fmt.Sprintf("Conflicting collection name: %q", name))
 		}
 	}
-	return watcher, nil
+	col, err := NewCollection(name, paths, srv.Config)
+	if err != nil {
+		return err
+	}
+	srv.Collections = append(srv.Collections, col)
+	return nil
 }
 
 func (srv *Server) Start() {
-	go srv.Watch()
-	go srv.UpdateOnChange()
+	for _, col := range srv.Collections {
+		go col.Watch()
+		go col.UpdateOnChange()
+	}
 	go srv.Serve()
 	select {}
 }
 
-func (srv *Server) Watch() {
-	log.Println("Watching", len(srv.Codex.Inputs), "docs for changes ...")
-	for {
-		select {
-		case event, ok := <-srv.watcher.Events:
-			if !ok {
-				log.Fatal("filesystem watcher crash!")
-			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				srv.updates <- srv.Codex.Inputs[event.Name]
-			}
-		case err, ok := <-srv.watcher.Errors:
-			if !ok {
-				log.Fatal("filesystem watcher crash!")
-			}
-			log.Println("watch error:", err)
-		}
+// handleIndex serves the list of collections.
+func (srv *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
 	}
-}
-
-func (srv *Server) UpdateOnChange() {
-	for {
-		select {
-		case codoc := <-srv.updates:
-			time.AfterFunc(debounceWait, func() {
-				srv.builds <- codoc
-			})
-		case codoc := <-srv.builds:
-			if codoc.CheckMtime().After(codoc.Btime) {
-				log.Println("building:", codoc.Path)
-				htmlStr, err := srv.Codex.Update(codoc)
-				if err != nil {
-					log.Fatal(err)
-				}
-				srv.UpdateClients(htmlStr)
-			}
-		}
+	w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+	data := struct {
+		Theme       *Theme
+		Collections []*Collection
+	}{srv.Config.theme(), srv.Collections}
+	if err := srv.Config.theme().Template.ExecuteTemplate(w, "index", data); err != nil {
+		log.Println("Failed to write collections index,", err)
 	}
 }
 
-func (srv *Server) UpdateClients(htmlStr string) {
-	for idx, ws := range srv.websockets {
-		log.Println("Updating", len(srv.websockets), "websocket(s)")
-		if err := ws.WriteMessage(websocket.TextMessage, []byte(htmlStr)); err != nil {
-			log.Println("Failed to write to websocket,", err)
-			srv.dropWebSocket(idx)
-		}
+// IsLoopback reports whether the given address, eg "127.0.0.1:8000", is only
+// reachable from this machine. An empty host, eg ":8000", means all
+// interfaces.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
 	}
-}
-
-func (srv *Server) dropWebSocket(idx int) {
-	log.Println("Dropping stale websocket:", srv.websockets[idx].RemoteAddr())
-	nsocks := len(srv.websockets)
-	srv.websockets[idx] = srv.websockets[nsocks-1]
-	srv.websockets = srv.websockets[:nsocks-1]
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (srv *Server) Serve() {
-	http.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
-		static := STATICS[r.URL.Path]
-		// note: this doesn't populate Content-Length which is mandatory!
-		w.Header().Set("Content-Type", static.ContentType)
-		fmt.Fprintf(w, static.Body)
-	})
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, srv.Codex.Html())
-	})
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ws, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			return // TODO when does this happen?
+	mux := http.NewServeMux()
+	mux.HandleFunc("/static/", srv.Config.theme().handleStatic)
+	mux.HandleFunc("/metrics", srv.handleMetrics)
+	if len(srv.Collections) == 1 && srv.Collections[0].Name == "" {
+		mux.Handle("/", srv.Collections[0].Handler())
+	} else {
+		for _, col := range srv.Collections {
+			mux.Handle(col.Prefix()+"/", http.StripPrefix(col.Prefix(), col.Handler()))
 		}
-		log.Println("Accepted new websocket from", r.RemoteAddr)
-		srv.websockets = append(srv.websockets, ws)
-	})
+		mux.HandleFunc("/", srv.handleIndex)
+	}
 
+	handler := srv.Auth.Wrap(mux)
+	if !srv.Auth.Enabled() && !IsLoopback(srv.Addr) {
+		log.Println("Warning: serving on", srv.Addr, "without authentication, see -token")
+	}
 	log.Println("Starting server at address", srv.Addr)
-	if err := http.ListenAndServe(srv.Addr, nil); err != nil {
+	var err error
+	if srv.TLSCert != "" {
+		err = http.ListenAndServeTLS(srv.Addr, srv.TLSCert, srv.TLSKey, handler)
+	} else {
+		err = http.ListenAndServe(srv.Addr, handler)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_AddCollection_names(t *testing.T) {
+	srv := NewServer("127.0.0.1:8000", Config{})
+	assert.NotNil(t, srv.AddCollection("no/slashes", []string{"notes.md"}))
+
+	srv.Collections = []*Collection{{Name: "notes"}}
+	assert.NotNil(t, srv.AddCollection("notes", []string{"notes.md"}))
+	assert.NotNil(t, srv.AddCollection("", []string{"notes.md"}))
+	assert.Equal(t, "/c/notes", srv.Collections[0].Prefix())
+}
+
+func Test_handleIndex(t *testing.T) {
+	srv := NewServer("127.0.0.1:8000", Config{})
+	srv.Collections = []*Collection{
+		{Name: "journal", Codex: &Codex{Inputs: map[string]*Document{"a.md": nil, "b.md": nil}}},
+		{Name: "runbooks", Codex: &Codex{Inputs: map[string]*Document{"c.md": nil}}},
+	}
+	rec := httptest.NewRecorder()
+	srv.handleIndex(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<a href="c/journal/">journal</a>`)
+	assert.Contains(t, rec.Body.String(), `2 input(s)`)
+
+	rec = httptest.NewRecorder()
+	srv.handleIndex(rec, httptest.NewRequest("GET", "/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_parseCollection(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "codex-temp-")
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.md", "b.md", "c.rst"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("# hi"), 0644)
+	}
+
+	name, paths, err := parseCollection("notes=" + filepath.Join(dir, "*.md") + "," + filepath.Join(dir, "c.rst"))
+	assert.Nil(t, err)
+	assert.Equal(t, "notes", name)
+	assert.Equal(t, 3, len(paths))
+
+	_, _, err = parseCollection("notes")
+	assert.NotNil(t, err)
+}
+
+func Test_ContentSecurityPolicy(t *testing.T) {
+	var scriptSrc []string
+	for _, directive := range strings.Split(ContentSecurityPolicy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) > 0 && fields[0] == "script-src" {
+			scriptSrc = fields[1:]
+		}
+	}
+	// every remote script of the page shells, and only those, by exact URL
+	var scripts []string
+	for _, tmpl := range []string{CodexOutputTemplate, PrintOutputTemplate} {
+		doc, _ := LoadHtml(tmpl)
+		doc.Find("script[src^='https://']").Each(func(i int, script *goquery.Selection) {
+			scripts = append(scripts, script.AttrOr("src", ""))
+		})
+	}
+	assert.Equal(t, 4, len(scripts))
+	for _, src := range scripts {
+		assert.Contains(t, scriptSrc, src)
+	}
+	assert.Equal(t, []string{"'self'", jqueryScript, markScript, mathjaxScript}, scriptSrc)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maximum length of the normalized text used to find a node in its source
+	sourceNeedleLength = 24
+)
+
+// pandoc's sourcepos extension, see MarkdownReader, annotates elements with
+// their source ranges, eg data-pos="notes.md@3:1-4:1", or several of them
+// separated by semicolons. Ranges are 1-based, columns of ends are exclusive.
+var (
+	dataPosRegex     = regexp.MustCompile(`(\d+):(\d+)-(\d+):(\d+)`)
+	dataPosAttrRegex = regexp.MustCompile(` data-pos="[^"]*"`)
+)
+
+// UnwrapSourcePos undoes the divs and spans that pandoc's sourcepos extension
+// wraps around elements that cannot carry a data-pos attribute themselves, eg
+// paragraphs and words:
+//    <div data-pos="3:1-4:1"><p><span data-pos="3:1-3:6">Hello</span></p></div>
+//      ==>  <p data-pos="3:1-4:1">Hello</p>
+// The position of a div goes to the block it wraps, or to its parent if it
+// wraps inline content, eg of a list item, and positions of spans are dropped.
+// Other data-pos attributes are left for AnnotateSourceLines().
+func UnwrapSourcePos(doc *goquery.Document) {
+	wrapper := func(node *html.Node) bool {
+		return len(node.Attr) == 1 && node.Attr[0].Key == "data-pos"
+	}
+	Unwrap(doc.Find("span[data-pos]").FilterFunction(func(i int, span *goquery.Selection) bool {
+		return wrapper(span.Get(0))
+	}))
+
+	divs := doc.Find("div[data-pos]").Nodes
+	// note: inner divs first, such that positions move to the innermost block
+	for i := len(divs) - 1; i >= 0; i-- {
+		div := divs[i]
+		if !wrapper(div) {
+			continue
+		}
+		var blocks []*html.Node
+		inline := false
+		for child := div.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode {
+				blocks = append(blocks, child)
+			} else if child.Type == html.TextNode && strings.TrimSpace(child.Data) != "" {
+				inline = true
+			}
+		}
+		target := div.Parent
+		if len(blocks) == 1 && !inline {
+			target = blocks[0]
+		}
+		sel := goquery.NewDocumentFromNode(target).Selection
+		if _, ok := sel.Attr("data-pos"); !ok && target.Type == html.ElementNode {
+			sel.SetAttr("data-pos", div.Attr[0].Val)
+		}
+		Unwrap(goquery.NewDocumentFromNode(div).Selection)
+	}
+}
+
+// AnnotateSourceLines sets the range of source lines each node in the
+// treeified doc came from, as 1-based inclusive line numbers:
+//    <div class="node ..." codex-line-start="12" codex-line-end="30">
+//
+// Docs converted with pandoc's sourcepos extension, see UnwrapSourcePos(),
+// are mapped by the positions pandoc reports, which are then removed. Nodes
+// of other docs, whose readers report no positions, are located by searching
+// for the text of their head in source, in document order, and end where the
+// next node that is not their descendant starts. This is a best guess: nodes
+// whose head text repeats earlier in source, eg in a table of contents, may be
+// located there instead. Either way, nodes that cannot be located are left
+// unannotated.
+func AnnotateSourceLines(doc *goquery.Document, source string) {
+	lines := strings.Split(source, "\n")
+	if positioned := doc.Find("[data-pos]"); positioned.Length() > 0 {
+		annotateSourcePos(doc, len(lines))
+		positioned.RemoveAttr("data-pos")
+		return
+	}
+
+	normLines := make([]string, len(lines))
+	for i, line := range lines {
+		normLines[i] = normalizeText(line)
+	}
+
+	nodes := doc.Find(".node")
+	starts := make([]int, nodes.Length()) // 0 means unknown
+	cursor := 0
+	nodes.Each(func(i int, node *goquery.Selection) {
+		needle := sourceNeedle(node)
+		if needle == "" {
+			return
+		}
+		// note: a node may start on the same line as the previous one, eg a
+		// list and its first item.
+		for j := cursor; j < len(lines); j++ {
+			if strings.Contains(normLines[j], needle) {
+				starts[i] = j + 1
+				cursor = j
+				return
+			}
+		}
+	})
+
+	nodes.Each(func(i int, node *goquery.Selection) {
+		if starts[i] == 0 {
+			return
+		}
+		// descendants of a node are contiguous in document order:
+		end := len(lines)
+		for next := i + node.Find(".node").Length() + 1; next < len(starts); next++ {
+			if starts[next] > 0 {
+				end = starts[next] - 1
+				break
+			}
+		}
+		for end > starts[i] && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		if end < starts[i] {
+			end = starts[i]
+		}
+		node.SetAttr("codex-line-start", strconv.Itoa(starts[i]))
+		node.SetAttr("codex-line-end", strconv.Itoa(end))
+	})
+}
+
+// annotateSourcePos is AnnotateSourceLines() for docs with data-pos
+// attributes: a node spans from the first to the last line of its positioned
+// elements, given a source of nlines.
+func annotateSourcePos(doc *goquery.Document, nlines int) {
+	doc.Find(".node").Each(func(i int, node *goquery.Selection) {
+		start, end := 0, 0
+		node.Find("[data-pos]").AddSelection(node.Filter("[data-pos]")).Each(func(i int, elem *goquery.Selection) {
+			ranges := dataPosRegex.FindAllStringSubmatch(elem.AttrOr("data-pos", ""), -1)
+			if ranges == nil {
+				return
+			}
+			first, last := ranges[0], ranges[len(ranges)-1]
+			from, _ := strconv.Atoi(first[1])
+			to, _ := strconv.Atoi(last[3])
+			if col, _ := strconv.Atoi(last[4]); col == 1 && to > from {
+				to-- // ends at the start of the next line
+			}
+			if start == 0 || from < start {
+				start = from
+			}
+			if to > end {
+				end = to
+			}
+		})
+		if start == 0 || start > nlines {
+			return
+		}
+		if end > nlines {
+			end = nlines
+		}
+		node.SetAttr("codex-line-start", strconv.Itoa(start))
+		node.SetAttr("codex-line-end", strconv.Itoa(end))
+	})
+}
+
+// SourceLines returns the 1-based inclusive line range of a node as annotated
+// by AnnotateSourceLines, ok is false if the node is not annotated.
+func SourceLines(node *goquery.Selection) (start int, end int, ok bool) {
+	start, err := strconv.Atoi(node.AttrOr("codex-line-start", ""))
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err = strconv.Atoi(node.AttrOr("codex-line-end", ""))
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// SourceSpan returns the original source text of a node, ok is false if the
+// node is not annotated or its source file has changed since the build the
+// node is from, ie its mtime is not the given one, see NodeRef.
+func SourceSpan(path string, mtime string, node *goquery.Selection) (string, bool) {
+	start, end, ok := SourceLines(node)
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || ToIso8601(info.ModTime()) != mtime {
+		return "", false
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return sourceLines(string(contents), start, end)
+}
+
+// sourceLines returns a 1-based inclusive range of lines of source, ok is
+// false if the range is not within source.
+func sourceLines(source string, start int, end int) (string, bool) {
+	lines := strings.Split(source, "\n")
+	if start < 1 || end < start || end > len(lines) {
+		return "", false
+	}
+	return strings.Join(lines[start-1:end], "\n") + "\n", true
+}
+
+// sourceNeedle returns the text by which a node is searched for in source:
+// a prefix of its head text, or of its body for headless nodes.
+func sourceNeedle(node *goquery.Selection) string {
+	text := HeadText(node)
+	if node.HasClass("headless") {
+		text = strings.SplitN(PlainText(node.ChildrenFiltered(".node-body")), "\n", 2)[0]
+	}
+	needle := []rune(normalizeText(text))
+	if len(needle) > sourceNeedleLength {
+		needle = needle[:sourceNeedleLength]
+	}
+	return string(needle)
+}
+
+// normalizeText keeps only lowercased letters and digits so that rendered
+// text can be matched against its markup, eg "Hello World" in "# *Hello* World".
+func normalizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
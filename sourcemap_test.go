@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_AnnotateSourceLines(t *testing.T) {
+	source := `# Title
+
+Hello *World*
+
+## Section
+
+* Li1
+* Li2
+`
+	doc, _ := LoadHtml(`
+		<h1>Title</h1>
+		<p>Hello <em>World</em></p>
+		<h2>Section</h2>
+		<ul><li>Li1</li><li>Li2</li></ul>
+	`)
+	Treeify(doc, DefaultHeadRules)
+	AnnotateSourceLines(doc, source)
+
+	lines := func(selector string) []int {
+		start, end, ok := SourceLines(doc.Find(selector).First())
+		assert.True(t, ok)
+		return []int{start, end}
+	}
+	assert.Equal(t, []int{1, 8}, lines(".node-depth-0"))
+	assert.Equal(t, []int{3, 3}, lines(".node-depth-1.headless"))
+	assert.Equal(t, []int{5, 8}, lines(".node-depth-1:not(.headless)"))
+	assert.Equal(t, []int{7, 7}, lines("li.node"))
+	assert.Equal(t, []int{8, 8}, lines("li.node:last-child"))
+}
+
+func Test_UnwrapSourcePos(t *testing.T) {
+	doc, _ := LoadHtml(`<h1 id="title" data-pos="1:1-2:1">Title</h1>` +
+		`<div data-pos="3:1-4:1"><p><span data-pos="3:1-3:6">Hello</span> <em data-pos="3:7-3:14">World</em></p></div>` +
+		`<div data-pos="5:1-7:1"><ul><li><div data-pos="5:3-6:1">Li1</div></li><li><div data-pos="6:3-7:1">Li2</div></li></ul></div>` +
+		`<div class="note" data-pos="7:1-8:1"><p>kept</p></div>`)
+	UnwrapSourcePos(doc)
+
+	html, _ := doc.Find("body").Html()
+	assert.Equal(t, `<h1 id="title" data-pos="1:1-2:1">Title</h1>`+
+		`<p data-pos="3:1-4:1">Hello <em data-pos="3:7-3:14">World</em></p>`+
+		`<ul data-pos="5:1-7:1"><li data-pos="5:3-6:1">Li1</li><li data-pos="6:3-7:1">Li2</li></ul>`+
+		`<div class="note" data-pos="7:1-8:1"><p>kept</p></div>`, html)
+}
+
+func Test_AnnotateSourceLines_sourcepos(t *testing.T) {
+	// the heuristic would go by the table of contents for the Section heading
+	source := `# Contents
+
+* Section
+
+# Section
+
+text
+`
+	doc, _ := LoadHtml(`<h1 id="contents" data-pos="1:1-2:1">Contents</h1>` +
+		`<div data-pos="3:1-4:1"><ul><li><div data-pos="3:3-4:1">Section</div></li></ul></div>` +
+		`<h1 id="section" data-pos="5:1-6:1">Section</h1>` +
+		`<div data-pos="7:1-8:1"><p>text</p></div>`)
+	UnwrapSourcePos(doc)
+	Treeify(doc, DefaultHeadRules)
+	AnnotateSourceLines(doc, source)
+
+	lines := func(node *goquery.Selection) []int {
+		start, end, ok := SourceLines(node)
+		assert.True(t, ok)
+		return []int{start, end}
+	}
+	assert.Equal(t, []int{1, 3}, lines(nodeByHead(doc, "Contents")))
+	assert.Equal(t, []int{5, 7}, lines(doc.Find(".node").Has("h1#section").Last()))
+	assert.Equal(t, 0, doc.Find("[data-pos]").Length())
+}
+
+func Test_contentHash_sourcepos(t *testing.T) {
+	// ids survive lines inserted above a node
+	ids := func(pos string) string {
+		doc, _ := LoadHtml(`<h1 data-pos="` + pos + `">Title</h1><div data-pos="` + pos + `"><p>text</p></div>`)
+		UnwrapSourcePos(doc)
+		Treeify(doc, DefaultHeadRules)
+		return doc.Find(".node").First().AttrOr("id", "")
+	}
+	assert.NotEqual(t, "", ids("1:1-2:1"))
+	assert.Equal(t, ids("1:1-2:1"), ids("12:1-13:1"))
+}
+
+func Test_SourceSpan(t *testing.T) {
+	dir := t.TempDir()
+	cdx := fixtureCodex(dir, Config{Edit: true}, fixtureInput{Name: "notes.html", Html: "<h1>One</h1>\n<p>first</p>\n<h1>Two</h1>\n<p>second</p>\n"})
+	path := filepath.Join(dir, "notes.html")
+	ref, err := cdx.LookupNode(nodeByHead(cdx.HtmlDoc, "Two").AttrOr("id", ""))
+	assert.Nil(t, err)
+
+	span, ok := SourceSpan(path, ref.Mtime, ref.Node)
+	assert.True(t, ok)
+	assert.Equal(t, "<h1>Two</h1>\n<p>second</p>\n", span)
+
+	// requests go by the build their node is from, while builds go on
+	col := &Collection{Codex: cdx}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			cdx.Update(cdx.Inputs[path])
+		}
+		close(done)
+	}()
+	for building := true; building; {
+		select {
+		case <-done:
+			building = false
+		default:
+		}
+		rec := httptest.NewRecorder()
+		col.handleSource(rec, httptest.NewRequest("GET", "/api/source?node="+ref.Node.AttrOr("id", ""), nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// the file changed since the build
+	later := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	_, ok = SourceSpan(path, ref.Mtime, ref.Node)
+	assert.False(t, ok)
+}
@@ -12,86 +12,203 @@ const debounce = (wait, func) => {
   };
 };
 
+// escapes text for HTML content and quoted attribute values
+const escapeHtml = (text) => $('<div>').text(text).html().replace(/"/g, '&quot;').replace(/'/g, '&#39;');
+
+// version of the websocket protocol, see protocol.go
+const PROTOCOL_VERSION = 1;
+
 class Codex {
   constructor(root) {
-    this.addFullScreenButtons();
+    // server-side configuration, see Codex.DOMSkeleton()
+    this.historyEnabled = $('meta[name="codex-history"]').length > 0;
+    this.editorUrl = $('meta[name="codex-editor-url"]').attr('content');
+    this.editEnabled = $('meta[name="codex-edit"]').length > 0;
+    this.journalEnabled = $('meta[name="codex-journal"]').length > 0;
+
+    this.addNodeButtons();
     this.initSearch();
 
     this.initNav();
     this.initHighlighting();
     this.initFolding();
     this.initFullScreen();
+    this.initCopy();
+    this.initTags();
+    this.initTasks();
+    this.initJournal();
+    this.initEditing();
+    this.initHistory();
     this.initWebSocket();
+    this.initKeyboard();
+    this.initScroll();
   }
 
-  addFullScreenButtons() {
+  // sourceArticles returns the <article> of each input, also while they are
+  // replaced by the merged timeline, see applyTimeline().
+  sourceArticles() {
+    return this.$articles || $('main article[codex-source]');
+  }
+
+  addNodeButtons() {
     $('.node:not(:has(.node))').addClass('node-leaf')
-    $('.node').each((idx, elem) => {
-      $(elem).append(`<div class="full-screen-button"> ⤢ </div>`);
+    $('.node').not(':has(> .node-buttons)').each((idx, elem) => {
+      const $buttons = $('<div class="node-buttons"></div>');
+      if (this.editorUrl && $(elem).is('[codex-line-start]')) {
+        const path = $(elem).closest('[codex-path]').attr('codex-path').split('/').map(encodeURIComponent).join('/');
+        const url = this.editorUrl
+          .split('{path}').join(path)
+          .split('{line}').join($(elem).attr('codex-line-start'));
+        $buttons.append($('<a class="node-button" title="open in editor"> ↗ </a>').attr('href', url));
+      }
+      if (this.editEnabled && $(elem).is('[codex-line-start]')) {
+        $buttons.append(`<div class="node-button edit-button" title="edit"> ✎ </div>`);
+      }
+      if (this.historyEnabled && !$(elem).is('li')) {
+        $buttons.append(`<div class="node-button history-button" title="history"> ↺ </div>`);
+      }
+      $buttons.append(`<div class="node-button full-screen-button" title="full screen"> ⤢ </div>`);
+      $buttons.append(`<a class="node-button" href="export?node=${elem.id}" target="_blank" title="print"> ⎙ </a>`);
+      $(elem).append($buttons);
+
+      $(elem).children('.node-head').append(`
+        <span class="copy-menu" title="copy as ...">
+          <span class="copy-as" codex-format="markdown">md</span>
+          <span class="copy-as" codex-format="plain">text</span>
+          <span class="copy-as" codex-format="html">rich</span>
+        </span>
+      `);
     });
   }
 
+  // initSearch sets up the search box, the query is kept in the URL as ?q=
+  // such that it survives reloads and can be shared. Queries are run by the
+  // server, see ParseQuery() in query.go for their syntax.
   initSearch() {
-    delete this.searchIndex
-    this.searchIndex = lunr(config => {
-      config.ref('id');
-      config.field('text');
+    $('#search').append('<div class="search-results"> <!-- populated by renderSearchResults() --> </div>');
+    const query = new URLSearchParams(document.location.search).get('q') || '';
+    $('#search input')
+      .val(query)
+      .on('keyup', debounce(400, event => this.search(event.target.value)));
+    $('#search').on('click', '.search-hit', event => {
+      this.revealNode($(event.target).closest('.search-hit').attr('codex-node'));
+    });
+    this.searchSeq = 0;
+    if (query) {
+      this.search(query);
+    }
+  }
 
-      $('.node-leaf').each((i, elem) => {
-        config.add({id: elem.id, text: elem.innerText});
-      });
-    })
+  search(query) {
+    const url = new URL(document.location.href);
+    if (query) {
+      url.searchParams.set('q', query);
+    } else {
+      url.searchParams.delete('q');
+    }
+    history.replaceState(null, '', url.href);
 
-    $('#search input').on('keyup', debounce(400, event => {
-      if (event.target.value == '') {
-        $('.node').removeClass('d-none')
-        $('#search label').text('');
-        return;
-      }
-      $('.node').addClass('d-none');
-      // query syntax: https://lunrjs.com/guides/searching.html
-      // bug: colon is broken because it gets interpreted as "field query"
-      const hits = this.searchIndex.search(event.target.value);
-      $('body').unmark({
-        done: () => {
-          for (const hit of hits) {
-            $(`#${hit.ref}`).removeClass('d-none');
-            $(`#${hit.ref}`).parents('.node').removeClass('d-none');
-            $(`#${hit.ref}`).mark(Object.keys(hit.matchData.metadata));
-          }
+    // responses may arrive out of order, only the last query counts
+    const seq = ++this.searchSeq;
+    if (query.trim() == '') {
+      $('.node').removeClass('d-none')
+      $('#search label').text('');
+      $('#search .search-results').empty();
+      $('body').unmark();
+      return;
+    }
+    fetch(`api/search?q=${encodeURIComponent(query)}`)
+      .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+      .then(results => {
+        if (seq == this.searchSeq) {
+          this.renderSearchResults(results);
+        }
+      })
+      .catch(err => {
+        if (seq == this.searchSeq) {
+          $('#search label').text(String(err).trim());
         }
       });
+  }
 
-      $('label[for="search-input"]').text(hits.length ? `${hits.length} nodes` : 'no matches');
-    }));
+  // shows only the nodes hit by a search, along with their ancestors, and
+  // lists them in the navigation grouped by article, best first. See
+  // Codex.Search() in search.go for the structure of results.
+  renderSearchResults(results) {
+    $('.node').addClass('d-none');
+    const $list = $('#search .search-results').empty();
+    let count = 0;
+    $('body').unmark({
+      done: () => {
+        for (const result of results) {
+          $list.append($('<div class="search-source"></div>').text((result.meta && result.meta.title) || result.source));
+          for (const hit of result.hits || []) {
+            const $node = $(`#${hit.id}`);
+            $node.removeClass('d-none');
+            $node.parents('.node').removeClass('d-none');
+            if (hit.matches) {
+              $node.mark(hit.matches, {separateWordSearch: false});
+            }
+            const $entry = $('<div class="search-hit"></div>').attr('codex-node', hit.id);
+            $entry.append($('<div class="search-hit-head"></div>').text(
+              hit.head.length ? hit.head[hit.head.length - 1] : hit.text.split('\n')[0]
+            ));
+            $entry.append($('<div class="search-hit-context"></div>').text(hit.head.slice(0, -1).join(' › ')));
+            $list.append($entry);
+            count++;
+          }
+        }
+        $('label[for="search-input"]').text(count ? `${count} nodes` : 'no matches');
+      }
+    });
   }
 
   initNav() {
-    $('main article[codex-source]').each((idx, elem) => {
+    $('nav #files').before(`
+      <div id="meta-controls">
+        <label> group by <select class="group-by"><option value="">none</option></select></label>
+        <span class="meta-filter"> <!-- populated by filterByMeta() --> </span>
+      </div>
+    `);
+    this.sourceArticles().each((idx, elem) => {
       const $article = $(elem);
       const fname = $article.attr('codex-source');
       $('nav #files').append(`
         <div class="nav-file" codex-source="${fname}">
           <div class="file-name"> ${fname} </div>
           <div class="last-updated"> <!-- popualted later --> </div>
+          <div class="file-tags"> <!-- popualted later --> </div>
         </div>
       `);
       this.renderLastUpdated($article);
+      this.renderMeta($article);
     });
+    this.renderMetaControls();
+
+    $('#meta-controls .group-by').on('change', event => this.groupByMeta(event.target.value));
+    $('body').on('click', '.meta-tag', event => {
+      event.stopPropagation();
+      const $tag = $(event.target);
+      this.filterByMeta($tag.attr('codex-key'), $tag.attr('codex-value'));
+    });
+    $('#meta-controls').on('click', '.meta-filter', () => this.filterByMeta(null));
 
     $('main').on('mouseenter', '.node', event => {
-      const $article = $(event.target).closest('article[codex-source]');
+      const $article = $(event.target).closest('[codex-source]');
       this.navForArticle($article).find('.file-name').addClass('bold');
     });
 
     $('main').on('mouseleave', '.node', event => {
-      const $article = $(event.target).closest('article[codex-source]');
+      const $article = $(event.target).closest('[codex-source]');
       this.navForArticle($article).find('.file-name').removeClass('bold');
     });
 
     $('.nav-file').on('click', event => {
       const fname = $(event.target).closest('.nav-file').attr('codex-source');
-      $(`article[codex-source="${fname}"]`)[0].scrollIntoView();
+      const $article = $(`main article[codex-source="${fname}"]`);
+      if ($article.length) {
+        $article[0].scrollIntoView();
+      }
     });
   }
 
@@ -106,6 +223,80 @@ class Codex {
     $(`nav #files div[codex-source="${fname}"] .last-updated`).html(mtime);
   }
 
+  // metadata attributes of an article, ie front matter, see Codex.Update()
+  articleMeta($article) {
+    const meta = {};
+    for (const attr of $article[0].attributes) {
+      const key = attr.name.replace(/^codex-/, '');
+      if (attr.name.startsWith('codex-') && !['source', 'path', 'mtime'].includes(key)) {
+        meta[key] = attr.value.split(',');
+      }
+    }
+    return meta;
+  }
+
+  renderMeta($article) {
+    const meta = this.articleMeta($article);
+    const $tags = this.navForArticle($article).find('.file-tags').empty();
+    for (const key of ['status', 'tags']) {
+      for (const value of meta[key] || []) {
+        $tags.append($('<span class="meta-tag"></span>').attr({'codex-key': key, 'codex-value': value}).text(value));
+      }
+    }
+  }
+
+  // populates the group-by options with all metadata keys in use
+  renderMetaControls() {
+    const keys = new Set();
+    this.sourceArticles().each((idx, elem) => {
+      Object.keys(this.articleMeta($(elem))).forEach(key => keys.add(key));
+    });
+    const $select = $('#meta-controls .group-by');
+    $select.find('option:not([value=""])').remove();
+    for (const key of [...keys].sort()) {
+      $select.append($('<option></option>').attr('value', key).text(key));
+    }
+    $select.val(this.groupKey || '');
+    $('#meta-controls').toggleClass('d-none', keys.size == 0);
+  }
+
+  // shows only articles whose metadata key includes value, null clears
+  filterByMeta(key, value) {
+    this.metaFilter = key ? {key: key, value: value} : null;
+    this.sourceArticles().each((idx, elem) => {
+      const $article = $(elem);
+      const values = this.articleMeta($article)[key] || [];
+      const hidden = key && !values.includes(value);
+      $article.toggleClass('d-none', !!hidden);
+      this.navForArticle($article).toggleClass('filtered-out', !!hidden);
+    });
+    $('#meta-controls .meta-filter').html(key ? `${escapeHtml(key)}: ${escapeHtml(value)} ✕` : '');
+  }
+
+  // groups file navigation entries by the values of a metadata key, an
+  // article with multiple values, eg tags, is listed under its first.
+  groupByMeta(key) {
+    this.groupKey = key;
+    const $files = $('nav #files');
+    $files.find('.nav-group').remove();
+    const $entries = $files.find('.nav-file').detach();
+    if (!key) {
+      $files.append($entries);
+      return;
+    }
+    const groups = {};
+    $entries.each((idx, elem) => {
+      const $article = this.sourceArticles().filter(`[codex-source="${$(elem).attr('codex-source')}"]`);
+      const value = (this.articleMeta($article)[key] || ['—'])[0];
+      (groups[value] = groups[value] || []).push(elem);
+    });
+    for (const value of Object.keys(groups).sort()) {
+      const $group = $('<div class="nav-group"><div class="nav-group-name"></div></div>');
+      $group.find('.nav-group-name').text(value);
+      $files.append($group.append(groups[value]));
+    }
+  }
+
   initHighlighting() {
     // nuance: moving cursor through nodes, in and out of nodes within a node
     // this needs to be a single event handler for the entire DOM
@@ -122,24 +313,240 @@ class Codex {
     });
   }
 
+  // initFolding sets up folding of nodes by clicking their heads, and the
+  // fold controls in nav. Folds are kept in local storage, per page, and
+  // restored on reloads and updates, see foldKey().
   initFolding() {
+    this.foldsKey = `codex-folds:${document.location.pathname}`;
     $('main').on('click', '.node-head', event => {
-      if (event.target.tagName == 'A') {
+      if (event.target.tagName == 'A' || $(event.target).closest('.copy-menu, .codex-tag').length) {
         return;
       }
       $(event.target).closest('.node').toggleClass('collapsed');
+      this.saveFolds();
+    });
+
+    $('nav #files').before(`
+      <div id="fold-controls">
+        <label> fold to depth <select class="fold-depth"><option value="">-</option></select></label>
+        <span class="expand-all"> expand all </span>
+      </div>
+    `);
+    const depths = new Set();
+    $('main .node').each((idx, elem) => {
+      const match = elem.className.match(/\bnode-depth-(\d+)\b/);
+      if (match) {
+        depths.add(parseInt(match[1]));
+      }
+    });
+    for (const depth of [...depths].sort((a, b) => a - b)) {
+      $('#fold-controls .fold-depth').append($('<option></option>').attr('value', depth).text(depth + 1));
+    }
+    $('#fold-controls .fold-depth').on('change', event => {
+      if (event.target.value !== '') {
+        this.foldToDepth(parseInt(event.target.value));
+      }
+    });
+    $('#fold-controls .expand-all').on('click', () => {
+      $('main .node').removeClass('collapsed');
+      $('#fold-controls .fold-depth').val('');
+      this.saveFolds();
     });
+
+    this.restoreFolds($('main'));
+  }
+
+  // foldToDepth shows nodes down to the given depth, collapsing those at it
+  foldToDepth(depth) {
+    $('main .node').removeClass('collapsed');
+    $(`main .node-depth-${depth}`).addClass('collapsed');
+    this.saveFolds();
+  }
+
+  // foldKey identifies a node across rebuilds, unlike its id which is a hash
+  // of its contents: the input and the heads leading to the node, eg
+  // "notes.md#Meetings / 2021-11-30". Headless nodes are keyed by their id.
+  foldKey($node) {
+    if ($node.hasClass('headless')) {
+      return $node.attr('id');
+    }
+    const heads = $node.parents('.node').addBack().not('.headless').map((idx, elem) => {
+      const $head = $(elem).children('.node-head').clone();
+      $head.find('.copy-menu').remove();
+      return $head.text().replace(/\s+/g, ' ').trim();
+    }).get();
+    return `${$node.closest('[codex-source]').attr('codex-source')}#${heads.join(' / ')}`;
+  }
+
+  saveFolds() {
+    const keys = $('main .node.collapsed').map((idx, elem) => this.foldKey($(elem))).get();
+    try {
+      localStorage.setItem(this.foldsKey, JSON.stringify(keys));
+    } catch (err) {
+      console.error('failed to save folds:', err);
+    }
+  }
+
+  // restoreFolds collapses the nodes under $root that were collapsed before
+  restoreFolds($root) {
+    let keys;
+    try {
+      keys = new Set(JSON.parse(localStorage.getItem(this.foldsKey) || '[]'));
+    } catch (err) {
+      return;
+    }
+    $root.find('.node').each((idx, elem) => {
+      const $node = $(elem);
+      $node.toggleClass('collapsed', keys.has(this.foldKey($node)));
+    });
+  }
+
+  // initScroll restores the scroll position of the page after a reload, unless
+  // the URL points at a node, eg one copied with the keyboard, see copyNodeLink()
+  initScroll() {
+    const key = `codex-scroll:${document.location.pathname}`;
+    const scrollY = sessionStorage.getItem(key);
+    const target = document.getElementById(decodeURIComponent(document.location.hash.slice(1)));
+    if (target && $(target).is('.node')) {
+      this.revealNode(target.id);
+      this.focusNode($(target));
+    } else if (scrollY !== null) {
+      window.scrollTo(0, parseInt(scrollY));
+    }
+    $(window).on('scroll', debounce(200, () => sessionStorage.setItem(key, window.scrollY)));
+  }
+
+  // initKeyboard sets up vim-style navigation of the node tree, see
+  // keyBindings for the keys. The node keys act on is marked .focused.
+  initKeyboard() {
+    this.keyBindings = [
+      {key: 'j', help: 'next sibling node', action: () => this.focusSibling(1)},
+      {key: 'k', help: 'previous sibling node', action: () => this.focusSibling(-1)},
+      {key: 'h', help: 'parent node', action: () => this.focusNode(this.focusedNode().parent().closest('.node'))},
+      {key: 'l', help: 'first child node, unfolding', action: () => this.focusChild()},
+      {key: 'o', help: 'fold or unfold node', action: () => this.toggleFocused()},
+      {key: 'J', help: 'next article', action: () => this.focusArticle(1)},
+      {key: 'K', help: 'previous article', action: () => this.focusArticle(-1)},
+      {key: 'f', help: 'show node in full screen', action: () => this.fullScreenFocused()},
+      {key: 'y', help: 'copy link to node', action: () => this.copyNodeLink()},
+      {key: '/', help: 'search', action: () => $('#search input').focus().select()},
+      {key: '?', help: 'show this help', action: () => this.showKeyboardHelp()},
+    ];
+    $(document).on('keydown', event => {
+      if (event.ctrlKey || event.metaKey || event.altKey) {
+        return;
+      }
+      if ($(event.target).is('input, textarea, select, [contenteditable]')) {
+        if (event.key === 'Escape') {
+          event.target.blur();
+        }
+        return;
+      }
+      const binding = this.keyBindings.find(binding => binding.key === event.key);
+      if (binding && !this.isInFullScreen()) {
+        event.preventDefault();
+        binding.action();
+      }
+    });
+  }
+
+  focusedNode() {
+    return $('main .node.focused').first();
+  }
+
+  focusNode($node) {
+    if (!$node.length) {
+      return;
+    }
+    $('.node.focused').removeClass('focused');
+    $node.addClass('focused');
+    $node[0].scrollIntoView({block: 'nearest'});
+  }
+
+  // childNodesOf returns the visible nodes right under a node, or an article,
+  // note: those are not necessarily its children in the DOM, eg list items.
+  childNodesOf($parent) {
+    return $parent.find('.node:visible').filter((idx, elem) => {
+      return $(elem).parent().closest('.node, article')[0] === $parent[0];
+    });
+  }
+
+  focusSibling(offset) {
+    const $node = this.focusedNode();
+    if (!$node.length) {
+      this.focusNode($('main .node:visible').first());
+      return;
+    }
+    const $siblings = this.childNodesOf($node.parent().closest('.node, article'));
+    const idx = $siblings.index($node) + offset;
+    if (idx >= 0) {
+      this.focusNode($siblings.eq(idx));
+    }
+  }
+
+  focusChild() {
+    const $node = this.focusedNode();
+    if ($node.hasClass('collapsed')) {
+      this.toggleFocused();
+    }
+    this.focusNode(this.childNodesOf($node).first());
+  }
+
+  focusArticle(offset) {
+    const $articles = $('main article[codex-source]:visible');
+    const current = $articles.index(this.focusedNode().closest('article'));
+    const idx = current < 0 ? 0 : current + offset;
+    if (idx >= 0) {
+      this.focusNode(this.childNodesOf($articles.eq(idx)).first());
+    }
+  }
+
+  toggleFocused() {
+    this.focusedNode().toggleClass('collapsed');
+    this.saveFolds();
+  }
+
+  fullScreenFocused() {
+    const $node = this.focusedNode();
+    if ($node.length) {
+      this.enterFullScreen($node[0].innerHTML);
+    }
+  }
+
+  // copyNodeLink copies a link to the focused node, opening it reveals the
+  // node, see initScroll(). Node ids change when their contents do.
+  copyNodeLink() {
+    const $node = this.focusedNode();
+    if (!$node.length) {
+      return;
+    }
+    const url = new URL(document.location.href);
+    url.hash = $node.attr('id');
+    navigator.clipboard.writeText(url.href)
+      .then(() => {
+        $node.addClass('flash');
+        setTimeout(() => $node.removeClass('flash'), 1500);
+      })
+      .catch(err => console.error('copy failed:', err));
+  }
+
+  showKeyboardHelp() {
+    const $help = $('<div class="keyboard-help"><h3>Keyboard shortcuts</h3><table></table></div>');
+    for (const binding of this.keyBindings) {
+      const $row = $('<tr><td><kbd></kbd></td><td class="key-help"></td></tr>');
+      $row.find('kbd').text(binding.key);
+      $row.find('.key-help').text(binding.help);
+      $help.find('table').append($row);
+    }
+    $help.find('table').append('<tr><td><kbd>Esc</kbd></td><td>leave search, full screen, or this help</td></tr>');
+    this.enterFullScreen($help);
   }
 
   initFullScreen() {
     // clicking on the full screen button populates the modal with the current
     // node and blurs the rest into the background
     $('body').on('click', '.full-screen-button', event => {
-      $('.node').removeClass('highlight');
-      $('body').addClass('full-screen');
-      $('#full-screen-modal').empty();
-      $('#full-screen-modal').append($(event.target).closest('.node')[0].innerHTML);
-      $('#full-screen-modal').removeClass('inactive');
+      this.enterFullScreen($(event.target).closest('.node')[0].innerHTML);
     });
 
     // While the modal is active, a click outside of it removes it
@@ -147,7 +554,7 @@ class Codex {
       if (!this.isInFullScreen()) {
         return;
       }
-      if ($(event.target).closest('.full-screen-button').length) {
+      if ($(event.target).closest('.node-button').length) {
         // this was the click that initiates full screen
         return;
       }
@@ -170,19 +577,542 @@ class Codex {
     return $('body').hasClass('full-screen');
   }
 
+  enterFullScreen(html) {
+    $('.node').removeClass('highlight');
+    $('body').addClass('full-screen');
+    $('#full-screen-modal').empty();
+    $('#full-screen-modal').append(html);
+    $('#full-screen-modal').removeClass('inactive');
+  }
+
   exitFullScreen() {
     $('body').removeClass('full-screen');
     $('#full-screen-modal').addClass('inactive');
   }
 
+  initTags() {
+    $('nav #files').after(`
+      <div id="tags">
+        <div class="tags-title"> tags <span class="tag-filter"></span></div>
+        <div class="tag-list"> <!-- populated by loadTags() --> </div>
+      </div>
+    `);
+    this.loadTags();
+
+    $('body').on('click', '.codex-tag, .tag-entry', event => {
+      event.stopPropagation();
+      const tag = $(event.target).closest('[codex-tag]').attr('codex-tag').toLowerCase();
+      this.filterByTag(this.tagFilter == tag ? null : tag);
+    });
+    $('#tags').on('click', '.tag-filter', () => this.filterByTag(null));
+  }
+
+  loadTags() {
+    fetch('api/tags')
+      .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+      .then(tags => {
+        this.tags = tags;
+        const $list = $('#tags .tag-list').empty();
+        for (const tag of Object.keys(tags)) {
+          const $entry = $('<span class="tag-entry"></span>').attr('codex-tag', tag);
+          $entry.text(tag).append($('<span class="tag-count"></span>').text(tags[tag].length));
+          $list.append($entry);
+        }
+        $('#tags').toggleClass('d-none', Object.keys(tags).length == 0);
+        if (this.tagFilter) {
+          this.filterByTag(this.tagFilter);
+        }
+      })
+      .catch(err => console.error('failed to load tags:', err));
+  }
+
+  // shows only nodes containing the given tag, along with their ancestors and
+  // descendants, null clears the filter.
+  filterByTag(tag) {
+    this.tagFilter = tag;
+    $('.tag-entry').removeClass('bold');
+    if (!tag) {
+      $('.node').removeClass('d-none');
+      $('#tags .tag-filter').text('');
+      return;
+    }
+    $('.node').addClass('d-none');
+    const refs = (this.tags && this.tags[tag]) || [];
+    for (const ref of refs) {
+      const $node = $(`#${ref.id}`);
+      $node.removeClass('d-none');
+      $node.parents('.node').removeClass('d-none');
+      $node.find('.node').removeClass('d-none');
+    }
+    $(`.tag-entry[codex-tag="${tag}"]`).addClass('bold');
+    $('#tags .tag-filter').text(`${tag}: ${refs.length} nodes ✕`);
+  }
+
+  initTasks() {
+    $('nav #files').after(`
+      <div id="tasks">
+        <div class="tasks-title"> open tasks <span class="task-count"></span></div>
+        <div class="task-list"> <!-- populated by loadTasks() --> </div>
+      </div>
+    `);
+    this.loadTasks();
+
+    $('#tasks').on('click', '.tasks-title', () => $('#tasks').toggleClass('collapsed'));
+    $('#tasks').on('click', '.task-entry', event => {
+      this.revealNode($(event.target).closest('.task-entry').attr('codex-node'));
+    });
+  }
+
+  loadTasks() {
+    fetch('api/tasks')
+      .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+      .then(tasks => {
+        const $list = $('#tasks .task-list').empty();
+        for (const task of tasks) {
+          const $entry = $('<div class="task-entry"></div>').attr('codex-node', task.id);
+          $entry.append($('<div class="task-text"></div>').text(task.text));
+          $entry.append($('<div class="task-context"></div>').text(
+            [task.source, ...task.head].join(' › ')
+          ));
+          $list.append($entry);
+        }
+        $('#tasks .task-count').text(tasks.length);
+        $('#tasks').toggleClass('d-none', tasks.length == 0);
+      })
+      .catch(err => console.error('failed to load tasks:', err));
+  }
+
+  // scrolls to a node, unfolding and unhiding it if necessary
+  revealNode(id) {
+    const $node = $(`#${id}`);
+    if (!$node.length) {
+      return;
+    }
+    $node.add($node.parents('.node')).removeClass('collapsed d-none');
+    $node[0].scrollIntoView({block: 'center'});
+    $node.addClass('flash');
+    setTimeout(() => $node.removeClass('flash'), 1500);
+  }
+
+  initJournal() {
+    if (!this.journalEnabled) {
+      return;
+    }
+    $('nav #files').before(`
+      <div id="journal">
+        <label> <input type="checkbox" class="timeline-toggle"> timeline </label>
+        <div class="date-range">
+          <input type="date" class="date-from"> – <input type="date" class="date-to">
+        </div>
+        <div class="calendar"> <!-- populated by renderCalendar() --> </div>
+        <div class="months"> <!-- populated by renderMonths() --> </div>
+      </div>
+    `);
+    this.loadTimeline();
+
+    $('#journal').on('change', 'input', () => this.applyTimeline());
+    $('#journal').on('click', '.month-entry, .calendar-step', event => {
+      this.renderCalendar($(event.target).closest('[codex-month]').attr('codex-month'));
+    });
+    $('#journal').on('click', '.calendar-day.has-entry', event => {
+      this.revealNode($(event.target).attr('codex-node'));
+    });
+  }
+
+  loadTimeline() {
+    fetch('api/timeline')
+      .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+      .then(entries => {
+        this.timeline = entries; // most recent first
+        this.renderMonths();
+        this.renderCalendar(this.calendarMonth || (entries.length ? entries[0].date.slice(0, 7) : null));
+        this.applyTimeline(true);
+      })
+      .catch(err => console.error('failed to load timeline:', err));
+  }
+
+  renderMonths() {
+    const counts = {};
+    for (const entry of this.timeline) {
+      const month = entry.date.slice(0, 7);
+      counts[month] = (counts[month] || 0) + 1;
+    }
+    const $months = $('#journal .months').empty();
+    for (const month of Object.keys(counts).sort().reverse()) {
+      const $entry = $('<span class="month-entry"></span>').attr('codex-month', month).text(month);
+      $months.append($entry.append($('<span class="tag-count"></span>').text(counts[month])));
+    }
+  }
+
+  // renders a month, eg "2021-11", as a calendar where days with journal
+  // entries link to them.
+  renderCalendar(month) {
+    const $calendar = $('#journal .calendar').empty();
+    if (!month) {
+      return;
+    }
+    this.calendarMonth = month;
+    const [year, mon] = month.split('-').map(Number);
+    const pad = n => String(n).padStart(2, '0');
+    const prev = mon == 1 ? `${year - 1}-12` : `${year}-${pad(mon - 1)}`;
+    const next = mon == 12 ? `${year + 1}-01` : `${year}-${pad(mon + 1)}`;
+    $calendar.append(`
+      <div class="calendar-nav">
+        <span class="calendar-step" codex-month="${prev}"> ◀ </span>
+        <span> ${month} </span>
+        <span class="calendar-step" codex-month="${next}"> ▶ </span>
+      </div>
+    `);
+
+    const entries = {};
+    for (const entry of this.timeline) {
+      // most recent first, keep the first entry in file order for each day
+      entries[entry.date] = entry;
+    }
+    const $grid = $('<div class="calendar-grid"></div>');
+    for (const day of ['Mo', 'Tu', 'We', 'Th', 'Fr', 'Sa', 'Su']) {
+      $grid.append(`<span class="calendar-weekday">${day}</span>`);
+    }
+    const offset = (new Date(year, mon - 1, 1).getDay() + 6) % 7; // Monday first
+    const ndays = new Date(year, mon, 0).getDate();
+    for (let i = 0; i < offset; i++) {
+      $grid.append('<span></span>');
+    }
+    for (let day = 1; day <= ndays; day++) {
+      const $day = $('<span class="calendar-day"></span>').text(day);
+      const entry = entries[`${month}-${pad(day)}`];
+      if (entry) {
+        $day.addClass('has-entry').attr({'codex-node': entry.id, title: entry.head});
+      }
+      $grid.append($day);
+    }
+    $calendar.append($grid);
+  }
+
+  // applies the date range filter and, if enabled, replaces the articles with
+  // the timeline of journal entries across all of them, most recent first, as
+  // merged by the server. Articles are kept aside in this.$articles until the
+  // timeline is disabled. A refresh fetches the timeline again.
+  applyTimeline(refresh) {
+    if (!$('#journal .timeline-toggle').is(':checked')) {
+      if (this.$articles) {
+        $('main article.codex-timeline').remove();
+        $('main').append(this.$articles);
+        this.$articles = null;
+        this.addNodeButtons();
+      }
+      this.filterDates();
+      return;
+    }
+    if (this.$articles && !refresh) {
+      this.filterDates();
+      return;
+    }
+    fetch('api/timeline?format=html')
+      .then(resp => resp.ok ? resp.text() : resp.text().then(msg => Promise.reject(msg)))
+      .then(html => {
+        if (!$('#journal .timeline-toggle').is(':checked')) {
+          return; // disabled while loading
+        }
+        const $timeline = $(new DOMParser().parseFromString(html, 'text/html')).find('article');
+        if (!this.$articles) {
+          this.$articles = $('main article[codex-source]').detach();
+        }
+        $('main article.codex-timeline').remove();
+        $('main').append($timeline);
+        this.addNodeButtons();
+        this.restoreFolds($timeline);
+        this.filterDates();
+      })
+      .catch(err => console.error('failed to load timeline:', err));
+  }
+
+  filterDates() {
+    const from = $('#journal .date-from').val();
+    const to = $('#journal .date-to').val();
+    $('.node[codex-date]').each((idx, elem) => {
+      const date = $(elem).attr('codex-date');
+      $(elem).toggleClass('d-none', !!((from && date < from) || (to && date > to)));
+    });
+  }
+
+  initCopy() {
+    $('main').on('click', '.copy-as', event => {
+      const $item = $(event.target);
+      const id = $item.closest('.node').attr('id');
+      this.copyNode(id, $item.attr('codex-format'))
+        .then(() => this.flash($item, 'copied'))
+        .catch(err => {
+          console.error('copy failed:', err);
+          this.flash($item, 'failed');
+        });
+    });
+  }
+
+  // copyNode puts a node in the clipboard, see /api/node for formats. Rich
+  // text is copied as HTML along with a plain text alternative.
+  copyNode(id, format) {
+    const blob = (fmt, mime) => fetch(`api/node?id=${id}&format=${fmt}`)
+      .then(resp => resp.ok ? resp.text() : resp.text().then(msg => Promise.reject(msg)))
+      .then(text => new Blob([text], {type: mime}));
+
+    // note: ClipboardItem takes promises so that the clipboard write happens
+    // synchronously within the click event, as required by some browsers.
+    const items = {'text/plain': blob(format == 'html' ? 'plain' : format, 'text/plain')};
+    if (format == 'html') {
+      items['text/html'] = blob('html', 'text/html');
+    }
+    return navigator.clipboard.write([new ClipboardItem(items)]);
+  }
+
+  flash($elem, text) {
+    const orig = $elem.text();
+    $elem.text(text);
+    setTimeout(() => $elem.text(orig), 1000);
+  }
+
+  initEditing() {
+    if (!this.editEnabled) {
+      return;
+    }
+    $('body').on('click', '.edit-button', event => {
+      const id = $(event.target).closest('.node').attr('id');
+      this.enterFullScreen('<div class="editor"> loading source ... </div>');
+      fetch(`api/source?node=${id}`)
+        .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+        .then(edit => this.renderEditor(edit))
+        .catch(err => $('#full-screen-modal .editor').text(`failed to load source: ${err}`));
+    });
+
+    $('body').on('click', '#full-screen-modal .editor-save', event => {
+      const $editor = $(event.target).closest('.editor');
+      // note: the rest of edit, eg the hash of the lines it replaces, goes
+      // back as served, see SourceEdit.
+      const edit = $editor.data('edit');
+      edit.text = $editor.find('textarea').val();
+      $editor.find('.editor-status').text('saving ...');
+      fetch('api/source', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(edit),
+      })
+        .then(resp => resp.ok ? null : resp.text().then(msg => Promise.reject(msg)))
+        .then(() => this.exitFullScreen())
+        .catch(err => $editor.find('.editor-status').text(`failed to save: ${err}`));
+    });
+    $('body').on('click', '#full-screen-modal .editor-cancel', event => this.exitFullScreen());
+  }
+
+  // renders the source of a node for editing in the full screen modal, see
+  // /api/source for the structure of edit.
+  renderEditor(edit) {
+    const $editor = $(`
+      <div class="editor">
+        <div class="editor-meta">
+          ${escapeHtml(edit.source)}, lines ${edit.start}-${edit.end}
+          <span class="editor-status"></span>
+        </div>
+        <textarea spellcheck="false"></textarea>
+        <div class="editor-actions">
+          <button class="editor-cancel"> cancel </button>
+          <button class="editor-save"> save </button>
+        </div>
+      </div>
+    `);
+    $editor.data('edit', edit);
+    $editor.find('textarea').val(edit.text).attr('rows', Math.min(40, edit.text.split('\n').length + 2));
+    $('#full-screen-modal').empty().append($editor);
+    $editor.find('textarea').focus();
+  }
+
+  initHistory() {
+    if (!this.historyEnabled) {
+      return;
+    }
+    $('body').on('click', '.history-button', event => {
+      const id = $(event.target).closest('.node').attr('id');
+      this.enterFullScreen('<div class="history"> loading history ... </div>');
+      fetch(`api/history?node=${id}`)
+        .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+        .then(history => this.renderHistory(history, 0))
+        .catch(err => $('#full-screen-modal .history').text(`failed to load history: ${err}`));
+    });
+
+    // note: handlers below re-render the modal, which detaches event.target;
+    // stop propagation so that the modal's click-outside handler ignores it.
+    $('body').on('click', '#full-screen-modal .history-step', event => {
+      event.stopPropagation();
+      const $button = $(event.target);
+      this.renderHistory($button.data('history'), $button.data('idx'));
+    });
+    $('body').on('click', '#full-screen-modal .history-article', event => {
+      event.stopPropagation();
+      const $link = $(event.target);
+      const query = `source=${encodeURIComponent($link.attr('codex-source'))}&commit=${$link.attr('codex-commit')}`;
+      fetch(`api/revision?${query}`)
+        .then(resp => resp.ok ? resp.text() : resp.text().then(msg => Promise.reject(msg)))
+        .then(html => {
+          $('#full-screen-modal .history-view').html(html);
+          MathJax.typeset();
+        })
+        .catch(err => $('#full-screen-modal .history-view').text(`failed to load revision: ${err}`));
+    });
+  }
+
+  // renders the idx'th revision of a node history in the full screen modal,
+  // see /api/history for the structure of history.
+  renderHistory(history, idx) {
+    const $history = $('<div class="history"></div>');
+    const revs = history.revisions || [];
+    if (!revs.length) {
+      $('#full-screen-modal').empty().append($history.text('no history for this node'));
+      return;
+    }
+    const rev = revs[idx];
+    $history.append(`
+      <div class="history-nav">
+        <button class="history-step older"> ◀ older </button>
+        <span> ${escapeHtml(history.head.join(' › '))}: revision ${idx + 1} of ${revs.length} </span>
+        <button class="history-step newer"> newer ▶ </button>
+      </div>
+      <div class="history-meta">
+        <code>${rev.commit.slice(0, 8)}</code> ${escapeHtml(rev.subject)}
+        <span class="last-updated"> ${escapeHtml(rev.author)}, ${(new Date(rev.date)).toLocaleString()} </span>
+        <a class="history-article" codex-source="${escapeHtml(history.source)}" codex-commit="${rev.commit}">view article at this revision</a>
+      </div>
+      <div class="history-view"><pre class="history-diff"></pre></div>
+    `);
+    $history.find('.older').data({history: history, idx: idx + 1}).prop('disabled', idx + 1 >= revs.length);
+    $history.find('.newer').data({history: history, idx: idx - 1}).prop('disabled', idx == 0);
+
+    const $diff = $history.find('.history-diff');
+    for (const line of rev.diff.split('\n')) {
+      const cls = line.startsWith('+') ? 'diff-add' : line.startsWith('-') ? 'diff-del' : '';
+      $diff.append($('<div>').addClass(cls).text(line));
+    }
+    $('#full-screen-modal').empty().append($history);
+  }
+
   initWebSocket() {
-    this.websocket = new WebSocket(`ws://${document.location.host}/ws`);
+    $('body').append('<div id="codex-status"></div>');
+    this.connected = false;
+    this.building = null;
+    this.failures = {}; // errors of failed builds by source
+    this.reconnectDelay = 1000;
+    this.connectWebSocket(false);
+  }
+
+  // connectWebSocket opens the websocket and reopens it, with exponential
+  // backoff, whenever it closes, eg after a server restart or a laptop sleep.
+  // Reconnecting catches up on the updates missed meanwhile, see resync().
+  connectWebSocket(reconnect) {
+    this.reconnecting = reconnect;
+    // note: auth cookies, if any, are sent along with the handshake.
+    // relative to the page, which may be that of a collection, eg /c/notes/
+    const url = new URL('ws', document.location.href);
+    url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+    // the server tells pages of its previous runs to reload, see bootId
+    url.searchParams.set('boot', $('meta[name="codex-boot"]').attr('content') || '');
+    this.websocket = new WebSocket(url.href, [`codex.v${PROTOCOL_VERSION}`]);
+    this.websocket.onopen = () => {
+      this.connected = true;
+      this.reconnectDelay = 1000;
+      this.renderStatus();
+    };
     this.websocket.onmessage = async (msg) => {
-      // assumes msg is html for an <article>, note: article ~ input doc
       const data = await msg.data;
       const text = (typeof data === 'string') ? data : await data.text();
-      this.onServerUpdate(text);
+      this.onServerMessage(JSON.parse(text));
+    };
+    this.websocket.onclose = () => {
+      this.connected = false;
+      this.building = null;
+      this.renderStatus();
+      setTimeout(() => this.connectWebSocket(true), this.reconnectDelay);
+      this.reconnectDelay = Math.min(2 * this.reconnectDelay, 30000);
+    };
+  }
+
+  // onServerMessage handles a message of the server, see Message in
+  // protocol.go. Unknown types are ignored, they are for newer clients.
+  onServerMessage(msg) {
+    if (msg.v !== PROTOCOL_VERSION) {
+      // the server was upgraded under this page, its scripts are stale
+      document.location.reload();
+      return;
+    }
+    if (msg.type === 'hello') {
+      if (this.reconnecting) {
+        this.resync();
+      }
+      this.seq = msg.seq;
+      return;
     }
+    if (msg.type === 'reload') {
+      document.location.reload();
+      return;
+    }
+    if (msg.seq !== this.seq + 1) {
+      this.resync(); // missed some
+    }
+    this.seq = msg.seq;
+
+    switch (msg.type) {
+      case 'build-started':
+        this.building = msg.source;
+        break;
+      case 'build-finished':
+        this.building = null;
+        delete this.failures[msg.source];
+        this.onServerUpdate(msg.payload.html);
+        break;
+      case 'build-failed':
+        this.building = null;
+        this.failures[msg.source] = msg.payload.error;
+        break;
+    }
+    this.renderStatus();
+  }
+
+  // resync replaces the articles that changed while the websocket was down,
+  // or reloads the page if the inputs themselves changed.
+  resync() {
+    fetch('api/articles')
+      .then(resp => resp.ok ? resp.json() : resp.text().then(msg => Promise.reject(msg)))
+      .then(versions => {
+        const $articles = this.sourceArticles();
+        const known = $articles.map((idx, elem) => $(elem).attr('codex-source')).get();
+        if (versions.length !== known.length || versions.some(version => !known.includes(version.source))) {
+          document.location.reload();
+          return;
+        }
+        versions
+          .filter(version => $articles.filter(`[codex-source="${version.source}"]`).attr('codex-mtime') !== version.mtime)
+          .forEach(version => {
+            fetch(`api/article?source=${encodeURIComponent(version.source)}`)
+              .then(resp => resp.ok ? resp.text() : resp.text().then(msg => Promise.reject(msg)))
+              .then(html => this.onServerUpdate(html))
+              .catch(err => console.error('resync failed:', err));
+          });
+      })
+      .catch(err => console.error('resync failed:', err));
+  }
+
+  renderStatus() {
+    const failed = Object.keys(this.failures);
+    let state = 'connected', text = '';
+    if (!this.connected) {
+      state = 'disconnected';
+      text = 'reconnecting ...';
+    } else if (this.building) {
+      state = 'building';
+      text = `building ${this.building}`;
+    } else if (failed.length) {
+      state = 'failed';
+      text = `build failed: ${failed.join(', ')}`;
+    }
+    const title = failed.map(source => `${source}: ${this.failures[source]}`).join('\n');
+    $('#codex-status').attr('class', state).attr('title', title || state).text(text);
   }
 
   onServerUpdate(html) {
@@ -191,11 +1121,32 @@ class Codex {
     const $article = $newDoc.find('article');
 
     const codexSource = $article.attr('codex-source');
-    $(`main article[codex-source="${codexSource}"]`).replaceWith($article);
+    if (this.$articles) {
+      // in timeline mode, see applyTimeline(), which loadTimeline() refreshes
+      this.$articles = this.$articles.map((idx, elem) => $(elem).attr('codex-source') === codexSource ? $article[0] : elem);
+    } else {
+      $(`main article[codex-source="${codexSource}"]`).replaceWith($article);
+    }
 
-    this.addFullScreenButtons();
-    this.initSearch();
+    this.addNodeButtons();
+    this.restoreFolds($article);
+    if ($('#search input').val()) {
+      this.search($('#search input').val());
+    }
     this.renderLastUpdated($article);
+    this.renderMeta($article);
+    this.renderMetaControls();
+    this.loadTags();
+    this.loadTasks();
+    if (this.journalEnabled) {
+      this.loadTimeline();
+    }
+    if (this.metaFilter) {
+      this.filterByMeta(this.metaFilter.key, this.metaFilter.value);
+    }
+    if (this.groupKey) {
+      this.groupByMeta(this.groupKey);
+    }
 
     // tell MathJax to look for unprocessed math and typeset it
     MathJax.typeset();
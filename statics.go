@@ -14,6 +14,12 @@ var pandocCss string
 //go:embed static/codex.svg
 var codexSvg string
 
+//go:embed static/print.css
+var printCss string
+
+//go:embed static/themes/dark.css
+var darkCss string
+
 type StaticFile struct {
 	Body        string
 	ContentType string
@@ -24,4 +30,7 @@ var STATICS = map[string]StaticFile{
 	"/static/codex.js":   StaticFile{Body: codexJs, ContentType: "text/javascript"},
 	"/static/pandoc.css": StaticFile{Body: pandocCss, ContentType: "text/css"},
 	"/static/codex.svg":  StaticFile{Body: codexSvg, ContentType: "image/svg+xml"},
+	"/static/print.css":  StaticFile{Body: printCss, ContentType: "text/css"},
+
+	"/static/themes/dark.css": StaticFile{Body: darkCss, ContentType: "text/css"},
 }
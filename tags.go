@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTagPatterns are the inline tag syntaxes recognized by default:
+// hashtags, eg #project-x, and mentions, eg @person.
+var DefaultTagPatterns = []string{`#\w[\w-]*`, `@\w[\w-]*`}
+
+// tagSkipElements are elements whose text is never tagged.
+var tagSkipElements = map[string]bool{
+	"a": true, "code": true, "pre": true, "script": true, "style": true,
+}
+
+// TagRef is an occurrence of a tag in a node.
+type TagRef struct {
+	Id     string   `json:"id"`
+	Source string   `json:"source"`
+	Head   []string `json:"head"` // see HeadPath()
+}
+
+// Tagger recognizes inline tags in text, see Tag().
+type Tagger struct {
+	regex *regexp.Regexp // nil means no tags
+}
+
+func NewTagger(patterns []string) (*Tagger, error) {
+	if len(patterns) == 0 {
+		return &Tagger{}, nil
+	}
+	var alternatives []string
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	regex, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, err
+	}
+	return &Tagger{regex: regex}, nil
+}
+
+// Tag wraps all inline tags in the treeified doc in tag spans:
+//    <span class="codex-tag" codex-tag="#project-x">#project-x</span>
+//
+// Text in code, links, and math is left alone. Tags must not be preceded by a
+// word character, eg email addresses are not mentions.
+func (tagger *Tagger) Tag(doc *goquery.Document) {
+	if tagger.regex == nil {
+		return
+	}
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			if tagSkipElements[node.Data] || hasClass(node, "math") || hasClass(node, "codex-tag") {
+				return
+			}
+		}
+		// note: tagging replaces text nodes, don't lose track of siblings
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			if child.Type == html.TextNode {
+				tagger.tagText(child)
+			} else {
+				walk(child)
+			}
+			child = next
+		}
+	}
+	for _, node := range doc.Find("body").Nodes {
+		walk(node)
+	}
+}
+
+// tagText replaces a text node by a sequence of text nodes and tag spans.
+func (tagger *Tagger) tagText(text *html.Node) {
+	var matches [][]int
+	for _, match := range tagger.regex.FindAllStringIndex(text.Data, -1) {
+		prev, _ := utf8.DecodeLastRuneInString(text.Data[:match[0]])
+		if match[0] > 0 && (unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '_') {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	parent := text.Parent
+	cursor := 0
+	for _, match := range matches {
+		if match[0] > cursor {
+			parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text.Data[cursor:match[0]]}, text)
+		}
+		tag := text.Data[match[0]:match[1]]
+		span := &html.Node{
+			Type: html.ElementNode,
+			Data: "span",
+			Attr: []html.Attribute{
+				{Key: "class", Val: "codex-tag"},
+				{Key: "codex-tag", Val: tag},
+			},
+		}
+		span.AppendChild(&html.Node{Type: html.TextNode, Data: tag})
+		parent.InsertBefore(span, text)
+		cursor = match[1]
+	}
+	if cursor < len(text.Data) {
+		parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text.Data[cursor:]}, text)
+	}
+	parent.RemoveChild(text)
+}
+
+// TagIndex returns the nodes each tag occurs in, keyed by lowercased tag.
+func TagIndex(article *goquery.Selection) map[string][]TagRef {
+	source := article.AttrOr("codex-source", "")
+	index := make(map[string][]TagRef)
+	article.Find("span.codex-tag").Each(func(i int, span *goquery.Selection) {
+		tag := strings.ToLower(span.AttrOr("codex-tag", ""))
+		node := span.Closest(".node")
+		id := node.AttrOr("id", "")
+		refs := index[tag]
+		if len(refs) > 0 && refs[len(refs)-1].Id == id {
+			return // same tag repeated in a node
+		}
+		index[tag] = append(refs, TagRef{Id: id, Source: source, Head: HeadPath(node)})
+	})
+	return index
+}
+
+func hasClass(node *html.Node, class string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == "class" {
+			for _, cls := range strings.Fields(attr.Val) {
+				if cls == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Tagger(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1>Standup #project-x</h1>
+		<p>Ask @alice, not bob@example.com, about <code>#include</code> and #Project-X.</p>
+	`)
+	Treeify(doc, DefaultHeadRules)
+	tagger, err := NewTagger(DefaultTagPatterns)
+	assert.Nil(t, err)
+	tagger.Tag(doc)
+
+	tags := doc.Find("span.codex-tag")
+	assert.Equal(t, 3, tags.Length())
+	assert.Equal(t, "#project-x", tags.Eq(0).AttrOr("codex-tag", ""))
+	assert.Equal(t, "@alice", selText(tags.Eq(1)))
+	assert.Equal(t, "#Project-X", selText(tags.Eq(2)))
+	assert.Contains(t, selText(doc.Find("p")), "Ask @alice, not bob@example.com")
+
+	doc.Find("body").SetAttr("codex-source", "notes.md")
+	index := TagIndex(doc.Find("body"))
+	assert.Equal(t, 2, len(index["#project-x"]))
+	assert.Equal(t, []string{"Standup #project-x"}, index["#project-x"][0].Head)
+	assert.Equal(t, 1, len(index["@alice"]))
+}
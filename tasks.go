@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// checkbox list items, eg "[ ] todo" or "[x] done". Newer versions of pandoc
+// render them as checkbox inputs instead, some older ones as ballot boxes.
+var checkboxRegex = regexp.MustCompile(`^(\[[ xX]\]|☐|☒)\s`)
+
+// TODO and DONE paragraphs, eg "TODO: call Bob"
+var todoRegex = regexp.MustCompile(`^(TODO|DONE)\b:?\s*`)
+
+// Task is a checkbox list item or a TODO line in an input.
+type Task struct {
+	Id     string   `json:"id"` // of the node containing the task
+	Text   string   `json:"text"`
+	Done   bool     `json:"done"`
+	Source string   `json:"source"`
+	Head   []string `json:"head"`           // see HeadPath()
+	Date   string   `json:"date,omitempty"` // of the closest date heading
+}
+
+// MarkTasks finds tasks in a treeified doc and marks them with a codex-task
+// class, and those that are done with an additional done class.
+func MarkTasks(doc *goquery.Document) {
+	doc.Find("li").Each(func(i int, li *goquery.Selection) {
+		text := listItemText(li)
+		checkbox := li.ChildrenFiltered(`input[type="checkbox"]`)
+		if match := checkboxRegex.FindStringSubmatch(text); match != nil {
+			li.AddClass("codex-task")
+			if match[1] != "[ ]" && match[1] != "☐" {
+				li.AddClass("done")
+			}
+		} else if checkbox.Length() > 0 {
+			li.AddClass("codex-task")
+			if _, checked := checkbox.Attr("checked"); checked {
+				li.AddClass("done")
+			}
+		}
+	})
+	doc.Find("p").Each(func(i int, p *goquery.Selection) {
+		match := todoRegex.FindStringSubmatch(strings.TrimSpace(p.Text()))
+		if match == nil {
+			return
+		}
+		p.AddClass("codex-task")
+		if match[1] == "DONE" {
+			p.AddClass("done")
+		}
+	})
+}
+
+// TaskList returns the tasks marked by MarkTasks in an <article>.
+func TaskList(article *goquery.Selection, dateFormats []string) []Task {
+	source := article.AttrOr("codex-source", "")
+	tasks := []Task{}
+	article.Find(".codex-task").Each(func(i int, elem *goquery.Selection) {
+		text := listItemText(elem)
+		text = checkboxRegex.ReplaceAllString(text, "")
+		text = todoRegex.ReplaceAllString(text, "")
+
+		node := elem.Closest(".node")
+		// the head path of a task is that of its enclosing node
+		head := HeadPath(node.ParentsFiltered(".node").First())
+		task := Task{
+			Id:     node.AttrOr("id", ""),
+			Text:   text,
+			Done:   elem.HasClass("done"),
+			Source: source,
+			Head:   head,
+		}
+		if date, ok := HeadPathDate(head, dateFormats); ok {
+			task.Date = date.Format("2006-01-02")
+		}
+		tasks = append(tasks, task)
+	})
+	return tasks
+}
+
+// SortTasks sorts tasks by date, most recent first, and keeps the original
+// order otherwise, ie by source and position within source.
+func SortTasks(tasks []Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].Date > tasks[j].Date
+	})
+}
+
+// listItemText returns the whitespace-normalized text of an element,
+// excluding nested lists.
+func listItemText(elem *goquery.Selection) string {
+	clone := elem.Clone()
+	clone.Find("ul, ol").Remove()
+	return strings.Join(strings.Fields(clone.Text()), " ")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_TaskList(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<h1>2021-11-30 Tue</h1>
+		<h2>Standup</h2>
+		<ul>
+			<li>[ ] call Bob</li>
+			<li>[x] email Alice</li>
+			<li><input type="checkbox" checked="" />review PR</li>
+			<li>not a task</li>
+		</ul>
+		<p>TODO: book flights</p>
+	`)
+	Treeify(doc, DefaultHeadRules)
+	MarkTasks(doc)
+
+	doc.Find("body").SetAttr("codex-source", "journal.md")
+	tasks := TaskList(doc.Find("body"), DefaultDateFormats)
+	assert.Equal(t, 4, len(tasks))
+
+	assert.Equal(t, "call Bob", tasks[0].Text)
+	assert.False(t, tasks[0].Done)
+	assert.Equal(t, "2021-11-30", tasks[0].Date)
+	assert.Equal(t, []string{"2021-11-30 Tue", "Standup"}, tasks[0].Head)
+	assert.Equal(t, "journal.md", tasks[0].Source)
+
+	assert.True(t, tasks[1].Done)
+	assert.True(t, tasks[2].Done)
+	assert.Equal(t, "review PR", tasks[2].Text)
+
+	assert.Equal(t, "book flights", tasks[3].Text)
+	assert.False(t, tasks[3].Done)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"strings"
 )
 
@@ -80,3 +81,38 @@ func selText(sel *goquery.Selection) string {
 func selCount(sel *goquery.Selection, selector string) int {
 	return sel.Find(selector).Length()
 }
+
+// fixtureInput is an input of a fixture codex, see fixtureCodex().
+type fixtureInput struct {
+	Name string // file name, eg notes.html, HTML inputs need no pandoc
+	Html string
+}
+
+// fixtureNotesHtml is a small input with nested, headless, and image nodes:
+//    One      (depth 0)
+//      first  (depth 1, headless)
+//      Sub    (depth 1) > fig.png
+//    Two      (depth 0) > second
+const fixtureNotesHtml = `
+	<h1>One</h1> <p>first</p>
+	<h2>Sub</h2> <p><img src="fig.png"></p>
+	<h1>Two</h1> <p>second</p>
+`
+
+// fixtureCodex writes the given inputs to dir and builds a codex of them the
+// way the server does, see NewCodex(). Crashes if it hits any errors.
+func fixtureCodex(dir string, conf Config, inputs ...fixtureInput) *Codex {
+	var paths []string
+	for _, input := range inputs {
+		path := filepath.Join(dir, input.Name)
+		if err := ioutil.WriteFile(path, []byte(input.Html), 0644); err != nil {
+			log.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	cdx, err := NewCodex(paths, conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cdx
+}
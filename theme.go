@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// themeTemplateFile is the file in a theme directory that overrides
+	// blocks of CodexOutputTemplate.
+	themeTemplateFile = "template.html"
+)
+
+// BuiltinThemes are the themes available by name, as stylesheets on top of
+// codex.css. The auto theme is dark or light depending on the preference of
+// the client's system.
+var BuiltinThemes = map[string][]Stylesheet{
+	"light": nil,
+	"dark":  {{Href: "static/themes/dark.css"}},
+	"auto":  {{Href: "static/themes/dark.css", Media: "(prefers-color-scheme: dark)"}},
+}
+
+type Stylesheet struct {
+	Href  string
+	Media string // eg "(prefers-color-scheme: dark)", empty means all
+}
+
+// Theme is the look of codex pages: the page template, see
+// CodexOutputTemplate, and the static files it refers to.
+type Theme struct {
+	Name     string
+	Template *template.Template
+
+	// Dir is an optional theme directory. Its files are served under
+	// /static/, taking precedence over STATICS, eg a codex.css in Dir
+	// replaces the built-in one. Other CSS and JS files in Dir are added to
+	// pages, and a template.html overrides blocks of CodexOutputTemplate, eg:
+	//    {{define "nav"}} <nav> ... </nav> {{end}}
+	Dir string
+
+	// Stylesheets and Scripts are added to pages after codex's own.
+	Stylesheets []Stylesheet
+	Scripts     []string
+}
+
+// DefaultTheme is the light built-in theme.
+var DefaultTheme = mustLoadTheme("light", "")
+
+func mustLoadTheme(name string, dir string) *Theme {
+	theme, err := LoadTheme(name, dir)
+	if err != nil {
+		panic(err)
+	}
+	return theme
+}
+
+// LoadTheme returns the built-in theme with the given name, customized by the
+// contents of dir, if any.
+func LoadTheme(name string, dir string) (*Theme, error) {
+	stylesheets, ok := BuiltinThemes[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown theme: %s", name))
+	}
+	tmpl, err := template.New("codex").Parse(CodexOutputTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.New("index").Parse(CollectionsIndexTemplate); err != nil {
+		return nil, err
+	}
+	theme := &Theme{
+		Name:        name,
+		Template:    tmpl,
+		Dir:         dir,
+		Stylesheets: append([]Stylesheet{}, stylesheets...),
+	}
+	if dir == "" {
+		return theme, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if name == themeTemplateFile {
+			contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			// note: redefining a block in the same set overrides it
+			if _, err := theme.Template.Parse(string(contents)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, builtin := STATICS["/static/"+name]; builtin {
+			continue // served in place of the built-in
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".css":
+			theme.Stylesheets = append(theme.Stylesheets, Stylesheet{Href: "static/" + name})
+		case ".js":
+			theme.Scripts = append(theme.Scripts, "static/"+name)
+		}
+	}
+
+	// the rest of codex relies on the page having a <main>
+	page, err := theme.Page()
+	if err != nil {
+		return nil, err
+	}
+	if doc, err := LoadHtml(page); err != nil || doc.Find("main").Length() == 0 {
+		return nil, errors.New(fmt.Sprintf("Theme template in %s has no <main>", dir))
+	}
+	return theme, nil
+}
+
+// Page returns the codex page shell, see Codex.DOMSkeleton().
+func (theme *Theme) Page() (string, error) {
+	var buf strings.Builder
+	if err := theme.Template.ExecuteTemplate(&buf, "codex", theme); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// handleStatic serves static files, from the theme directory if it has them,
+// otherwise from STATICS.
+func (theme *Theme) handleStatic(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	if theme.Dir != "" && !strings.Contains(name, "/") && name != themeTemplateFile {
+		path := filepath.Join(theme.Dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			http.ServeFile(w, r, path)
+			return
+		}
+	}
+	static := STATICS[r.URL.Path]
+	// note: this doesn't populate Content-Length which is mandatory!
+	w.Header().Set("Content-Type", static.ContentType)
+	// note: not a format string, codex.js has % in it
+	io.WriteString(w, static.Body)
+}
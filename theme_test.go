@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadTheme_builtin(t *testing.T) {
+	page, err := DefaultTheme.Page()
+	assert.Nil(t, err)
+	doc, _ := LoadHtml(page)
+	assert.Equal(t, 1, selCount(doc.Selection, "main"))
+	assert.Equal(t, 1, selCount(doc.Selection, "nav #search input"))
+	assert.Equal(t, 0, selCount(doc.Selection, `link[href="static/themes/dark.css"]`))
+
+	theme, err := LoadTheme("auto", "")
+	assert.Nil(t, err)
+	page, _ = theme.Page()
+	doc, _ = LoadHtml(page)
+	dark := doc.Find(`link[href="static/themes/dark.css"]`)
+	assert.Equal(t, "(prefers-color-scheme: dark)", dark.AttrOr("media", ""))
+
+	_, err = LoadTheme("neon", "")
+	assert.NotNil(t, err)
+}
+
+func Test_LoadTheme_dir(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "codex-temp-")
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "template.html"), []byte(`{{define "nav"}}<nav id="custom"></nav>{{end}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "extra.css"), []byte("body {}"), 0644)
+	os.WriteFile(filepath.Join(dir, "codex.css"), []byte("/* mine */"), 0644)
+
+	theme, err := LoadTheme("dark", dir)
+	assert.Nil(t, err)
+	page, _ := theme.Page()
+	doc, _ := LoadHtml(page)
+	assert.Equal(t, 1, selCount(doc.Selection, "nav#custom"))
+	assert.Equal(t, 0, selCount(doc.Selection, "#search"))
+	assert.Equal(t, 1, selCount(doc.Selection, `link[href="static/themes/dark.css"]`))
+	assert.Equal(t, 1, selCount(doc.Selection, `link[href="static/extra.css"]`))
+	// overrides of built-in statics are not added twice
+	assert.Equal(t, 1, selCount(doc.Selection, `link[href="static/codex.css"]`))
+
+	rec := httptest.NewRecorder()
+	theme.handleStatic(rec, httptest.NewRequest("GET", "/static/codex.css", nil))
+	assert.Equal(t, "/* mine */", rec.Body.String())
+	rec = httptest.NewRecorder()
+	theme.handleStatic(rec, httptest.NewRequest("GET", "/static/codex.js", nil))
+	assert.Equal(t, codexJs, rec.Body.String())
+
+	os.WriteFile(filepath.Join(dir, "template.html"), []byte(`{{define "main"}}<div></div>{{end}}`), 0644)
+	_, err = LoadTheme("light", dir)
+	assert.NotNil(t, err)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func _codexTransform(paths []string) *goquery.Document {
-	cdx, err := NewCodex(paths)
+	cdx, err := NewCodex(paths, Config{})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+// TreeNode is the JSON representation of a node and its subtree, see
+// Codex.Tree(), for tools that consume codex's structure rather than its HTML.
+type TreeNode struct {
+	Id       string `json:"id"`
+	Depth    int    `json:"depth"`
+	Head     string `json:"head"`     // whitespace-normalized text
+	HeadTag  string `json:"head_tag"` // eg h2, or li for list items, empty if headless
+	Headless bool   `json:"headless"`
+
+	// Html and Text are the node's body, without its child nodes.
+	Html string `json:"html"`
+	Text string `json:"text"`
+
+	Source   string      `json:"source"` // path of the input
+	Mtime    string      `json:"mtime"`  // of the input at its last build
+	Children []*TreeNode `json:"children"`
+}
+
+// Tree returns the top-level nodes in the given scope, see ExportScope, along
+// with their subtrees.
+func (cdx *Codex) Tree(scope ExportScope) ([]*TreeNode, error) {
+	if scope.Node != "" {
+		ref, err := cdx.LookupNode(scope.Node)
+		if err != nil {
+			return nil, err
+		}
+		return []*TreeNode{treeNode(ref.Node, ref.Source.Path, ref.Mtime)}, nil
+	}
+
+	articles, _, err := cdx.exportSelection(scope)
+	if err != nil {
+		return nil, err
+	}
+	roots := []*TreeNode{}
+	articles.Each(func(i int, article *goquery.Selection) {
+		source, mtime := article.AttrOr("codex-source", ""), article.AttrOr("codex-mtime", "")
+		childNodes(article).Each(func(i int, node *goquery.Selection) {
+			roots = append(roots, treeNode(node, source, mtime))
+		})
+	})
+	return roots, nil
+}
+
+// treeNode returns the TreeNode of the given treeified node.
+func treeNode(node *goquery.Selection, source string, mtime string) *TreeNode {
+	depth, _ := nodeDepth(node)
+	tnode := &TreeNode{
+		Id:       node.AttrOr("id", ""),
+		Depth:    depth,
+		Headless: node.HasClass("headless"),
+		Source:   source,
+		Mtime:    mtime,
+		Children: []*TreeNode{},
+	}
+	if !tnode.Headless {
+		tnode.Head = HeadText(node)
+		tnode.HeadTag = goquery.NodeName(node.ChildrenFiltered(".node-head").Children().First())
+		if node.Is("li") {
+			tnode.HeadTag = "li" // the head of an <li> is its leading text
+		}
+	}
+
+	body := node.ChildrenFiltered(".node-body").Clone()
+	body.Find(".node").Remove()
+	tnode.Html = strings.TrimSpace(InnerHtml(body))
+	tnode.Text = strings.Join(strings.Fields(body.Text()), " ")
+
+	childNodes(node).Each(func(i int, child *goquery.Selection) {
+		tnode.Children = append(tnode.Children, treeNode(child, source, mtime))
+	})
+	return tnode
+}
+
+// childNodes returns the nodes directly under the given node, or article,
+// ie those that have no other node in between.
+func childNodes(parent *goquery.Selection) *goquery.Selection {
+	return parent.Find(".node").FilterFunction(func(i int, node *goquery.Selection) bool {
+		closest := node.Parent().Closest(".node, article")
+		return closest.Length() > 0 && closest.Get(0) == parent.Get(0)
+	})
+}
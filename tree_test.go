@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Tree(t *testing.T) {
+	dir := t.TempDir()
+	cdx := fixtureCodex(dir, Config{}, fixtureInput{Name: "a.html", Html: fixtureNotesHtml})
+	cdx.HtmlDoc.Find("article").SetAttr("codex-mtime", "2021-11-30T10:00:00Z")
+
+	roots, err := cdx.Tree(ExportScope{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(roots))
+	one := roots[0]
+	assert.Equal(t, "One", one.Head)
+	assert.Equal(t, "h1", one.HeadTag)
+	assert.Equal(t, 0, one.Depth)
+	assert.Equal(t, filepath.Join(dir, "a.html"), one.Source)
+	assert.Equal(t, "2021-11-30T10:00:00Z", one.Mtime)
+	// the body of a node excludes its child nodes
+	assert.Equal(t, "", one.Text)
+	assert.Equal(t, 2, len(one.Children))
+
+	first := one.Children[0]
+	assert.True(t, first.Headless)
+	assert.Equal(t, "", first.HeadTag)
+	assert.Equal(t, "first", first.Text)
+	assert.Equal(t, "<p>first</p>", first.Html)
+	assert.Equal(t, 1, first.Depth)
+	assert.Equal(t, []*TreeNode{}, first.Children)
+
+	sub := one.Children[1]
+	assert.Equal(t, "h2", sub.HeadTag)
+	assert.Equal(t, 1, len(sub.Children))
+
+	roots, err = cdx.Tree(ExportScope{Node: sub.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(roots))
+	assert.Equal(t, "Sub", roots[0].Head)
+	assert.Equal(t, "2021-11-30T10:00:00Z", roots[0].Mtime)
+	assert.Equal(t, sub.Children[0].Id, roots[0].Children[0].Id)
+
+	_, err = cdx.Tree(ExportScope{Node: "node-missing"})
+	assert.NotNil(t, err)
+}
+
+func Test_Tree_listItems(t *testing.T) {
+	doc, _ := LoadHtml(`<h1>List</h1> <ul><li>one<ul><li>nested</li></ul></li><li>two</li></ul>`)
+	Treeify(doc, DefaultHeadRules)
+
+	list := treeNode(doc.Find(".node-depth-0"), "a.md", "")
+	assert.True(t, list.Children[0].Headless)
+	items := list.Children[0].Children
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "li", items[0].HeadTag)
+	assert.Equal(t, "one", items[0].Head)
+	assert.Equal(t, "nested", items[0].Text)
+	assert.Equal(t, "two", items[1].Head)
+	assert.Equal(t, "", items[1].Html)
+}
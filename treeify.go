@@ -3,8 +3,10 @@ package main
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
 	"golang.org/x/net/html"
 	"log"
 	"strings"
@@ -26,31 +28,113 @@ type PreNode struct {
 	Depth int
 }
 
-const tmpHeadClass string = "tmp-codex-head-class"
-
 // Treeify is the main entrypoint for tree munging code. Its core
-// traversal+transformation algo is implemented in treeify().
-func Treeify(doc *goquery.Document) {
-	doc.Find(strings.Join(HeadSelectors, ", ")).AddClass(tmpHeadClass)
-	treeify(doc.Find("body").First(), 0)
-	doc.Find("." + tmpHeadClass).RemoveClass(tmpHeadClass)
+// traversal+transformation algo is implemented in treeify(). The given rules
+// decide which elements are heads, see HeadRule.
+func Treeify(doc *goquery.Document, rules HeadRules) {
+	rules.unwrap(doc)
+	treeify(doc.Find("body").First(), 0, rules.ranks(doc))
 }
 
 // Heads are elements in the DOM that trigger node creation. They become the
 // node-head, and all their following siblings until the next head form the
-// node-body.
-var HeadSelectors = []string{"h1", "h2", "h3", "h4", "h5", "h6", "hr"}
-
-// The rank of a heading is its index in HeadSelectors. The relative value of
-// ranks between different nodes is what dictates their relative tree position.
-func rankOfHead(head *goquery.Selection) int {
-	for i := 0; i < len(HeadSelectors); i++ {
-		if head.Is(HeadSelectors[i]) {
-			return i
+// node-body. A HeadRule declares which elements are heads and their rank.
+//
+// The relative value of ranks between different heads is what dictates their
+// relative tree position: a head's node contains all subsequent heads up to
+// the first one with the same or a lower rank.
+type HeadRule struct {
+	Selector string `yaml:"selector"`
+	Rank     int    `yaml:"rank"`
+
+	// Match optionally restricts the elements matched by Selector, see
+	// headMatchers, eg "bold-only" for paragraphs consisting of bold text.
+	Match string `yaml:"match,omitempty"`
+
+	// Unwrap optionally selects wrapper elements to dissolve before treeify,
+	// such that heads inside them become siblings of the rest, eg "dl" for
+	// "dt" heads, or "details" for "summary" heads.
+	Unwrap string `yaml:"unwrap,omitempty"`
+}
+
+type HeadRules []HeadRule
+
+var DefaultHeadRules = HeadRules{
+	{Selector: "h1", Rank: 0},
+	{Selector: "h2", Rank: 1},
+	{Selector: "h3", Rank: 2},
+	{Selector: "h4", Rank: 3},
+	{Selector: "h5", Rank: 4},
+	{Selector: "h6", Rank: 5},
+	{Selector: "hr", Rank: 6},
+}
+
+// headMatchers are the available values of HeadRule.Match.
+var headMatchers = map[string]func(*goquery.Selection) bool{
+	// eg <p><strong>Title</strong></p>
+	"bold-only": func(elem *goquery.Selection) bool {
+		bold := elem.ChildrenFiltered("strong, b")
+		return elem.Children().Length() == 1 && bold.Length() == 1 &&
+			strings.TrimSpace(elem.Text()) == strings.TrimSpace(bold.Text())
+	},
+}
+
+// Validate reports the first invalid selector or matcher in the rules.
+func (rules HeadRules) Validate() error {
+	for _, rule := range rules {
+		if _, err := cascadia.ParseGroup(rule.Selector); err != nil {
+			return errors.New(fmt.Sprintf("Invalid head selector %q: %s", rule.Selector, err))
+		}
+		if _, ok := headMatchers[rule.Match]; rule.Match != "" && !ok {
+			return errors.New(fmt.Sprintf("Unknown head match %q", rule.Match))
+		}
+		if rule.Unwrap == "" {
+			continue
+		}
+		if _, err := cascadia.ParseGroup(rule.Unwrap); err != nil {
+			return errors.New(fmt.Sprintf("Invalid unwrap selector %q: %s", rule.Unwrap, err))
+		}
+	}
+	return nil
+}
+
+func (rules HeadRules) unwrap(doc *goquery.Document) {
+	for _, rule := range rules {
+		if rule.Unwrap != "" {
+			Unwrap(doc.Find(rule.Unwrap))
+		}
+	}
+}
+
+// headRanks maps head elements to their ranks.
+type headRanks map[*html.Node]int
+
+// ranks finds all heads in doc. An element matching multiple rules takes the
+// lowest rank among them.
+func (rules HeadRules) ranks(doc *goquery.Document) headRanks {
+	ranks := make(headRanks)
+	for _, rule := range rules {
+		matches := doc.Find(rule.Selector)
+		if match, ok := headMatchers[rule.Match]; ok {
+			matches = matches.FilterFunction(func(i int, elem *goquery.Selection) bool {
+				return match(elem)
+			})
+		}
+		for _, node := range matches.Nodes {
+			if rank, ok := ranks[node]; !ok || rule.Rank < rank {
+				ranks[node] = rule.Rank
+			}
 		}
 	}
-	log.Fatal("unexpected head", OuterHtml(head))
-	return -1
+	return ranks
+}
+
+// heads returns the heads in the selection, in tree order.
+func (ranks headRanks) heads(sel *goquery.Selection) *goquery.Selection {
+	return sel.FilterFunction(func(i int, elem *goquery.Selection) bool {
+		_, ok := ranks[elem.Get(0)]
+		return ok
+	})
 }
 
 // nodify turns a PreNode into a Node, in place. This is a unit
@@ -122,20 +206,35 @@ func nodifyListItem(liNode *html.Node) {
 	bodySel.WrapHtml("<div class='node-body'></span>")
 }
 
+// nodifyCell turns a notebook code cell, see LoadNotebook(), into a node in
+// place. Like an <li>, a cell is a node whose head is not a child of body:
+//    <div class="codex-cell node node-depth-3">
+//      <div class="node-head"> <div class="codex-cell-in"> In [3]: </div> </div>
+//      <div class="node-body"> <pre> ... </pre> <div class="codex-cell-output"> ...
+func nodifyCell(cell *goquery.Selection, depth int) {
+	label := cell.ChildrenFiltered(".codex-cell-in")
+	if label.Length() == 0 {
+		return
+	}
+	label.WrapAllHtml("<div class='node-head'></div>")
+	cell.Children().Not(".node-head").WrapAllHtml("<div class='node-body'></div>")
+	cell.AddClass(fmt.Sprintf("node node-depth-%d", depth))
+	cell.SetAttr("id", fmt.Sprintf("node-%s", contentHash(cell)))
+}
+
 // treeify recursively traverses the DOM and performs a sequence of in-place
 // transformations that make the tree structure of the DOM match the semantic
 // hierarchy of document sections, aka nodes.
-func treeify(root *goquery.Selection, depth int) {
+func treeify(root *goquery.Selection, depth int, ranks headRanks) {
 	if root.Length() > 1 {
 		log.Fatal("expected a single root element!")
 	}
 
-	// caution: the point of tmpHeadClass is the following query.
-	// If we simply concatenate head selectors with ",", the resulting selection
-	// will *not* necessarily be in correct tree order. For example if you ask
-	// for `h1, h2, h3` in `<h3>...</h3> ... <h2>...</h2>` you'll get the h2
-	// before the h3.
-	firstHead := root.ChildrenFiltered("." + tmpHeadClass).First()
+	// caution: heads are filtered out of children, as opposed to queried with
+	// the selectors of rules, because the latter is *not* necessarily in
+	// correct tree order. For example if you ask for `h1, h2, h3` in
+	// `<h3>...</h3> ... <h2>...</h2>` you'll get the h2 before the h3.
+	firstHead := ranks.heads(root.Children()).First()
 	if firstHead.Length() == 0 {
 		treeifyWithoutHeads(root, depth)
 		return
@@ -153,10 +252,10 @@ func treeify(root *goquery.Selection, depth int) {
 		// Given curHead H, nextHead is the first next sibling of H which is a
 		// head with rank <= rank(H). All the nodes in between form the body of
 		// the node rooted at H.
-		nextHead = findNextHead(curHead)
+		nextHead = findNextHead(curHead, ranks)
 		curBody = curHead.NextUntilSelection(nextHead)
 		nodify(PreNode{curHead, curBody, depth})
-		treeify(curBody.Parent(), depth+1) // <= recurse
+		treeify(curBody.Parent(), depth+1, ranks) // <= recurse
 
 		curHead = nextHead
 	}
@@ -171,22 +270,59 @@ func treeifyWithoutHeads(root *goquery.Selection, depth int) {
 			Depth: depth,
 		})
 		node.AddClass("headless")
+		if child.Is("div.codex-cell") {
+			nodifyCell(child, depth+1)
+		}
 	})
 }
 
-func findNextHead(curHead *goquery.Selection) *goquery.Selection {
-	curRank := rankOfHead(curHead)
-	return curHead.NextAllFiltered(
-		strings.Join(HeadSelectors[:curRank+1], ", "),
-	).First()
+func findNextHead(curHead *goquery.Selection, ranks headRanks) *goquery.Selection {
+	curRank := ranks[curHead.Get(0)]
+	return ranks.heads(curHead.NextAll()).FilterFunction(
+		func(i int, head *goquery.Selection) bool {
+			return ranks[head.Get(0)] <= curRank
+		}).First()
 }
 
+// contentHash returns the id of a node, a hash of its contents. Source
+// positions are not part of its contents, see UnwrapSourcePos(), such that
+// ids survive edits elsewhere in the source.
 func contentHash(node *goquery.Selection) string {
 	htmlStr, err := goquery.OuterHtml(node)
 	if err != nil {
 		log.Fatal(err)
 	}
+	htmlStr = dataPosAttrRegex.ReplaceAllString(htmlStr, "")
 	hash := md5.Sum([]byte(htmlStr))
 	contentId := hex.EncodeToString(hash[:])[:8]
 	return string(contentId)
 }
+
+// HeadPath returns the head texts of the given node and all its ancestor
+// nodes, outermost first. Headless nodes do not contribute to the path.
+func HeadPath(node *goquery.Selection) []string {
+	path := []string{}
+	if node.Length() == 0 {
+		return path
+	}
+	// self first, then ancestors in closest-first order, hence prepending.
+	// note: First() shares its nodes with the given selection, adding to it
+	// would clobber the caller's selection.
+	self := node.First()
+	nodes := append([]*html.Node{self.Get(0)}, self.ParentsFiltered(".node").Nodes...)
+	for _, cur := range nodes {
+		sel := &goquery.Selection{Nodes: []*html.Node{cur}}
+		if sel.HasClass("headless") {
+			continue
+		}
+		if text := HeadText(sel); text != "" {
+			path = append([]string{text}, path...)
+		}
+	}
+	return path
+}
+
+// HeadText returns the whitespace-normalized text of a node's own head.
+func HeadText(node *goquery.Selection) string {
+	return strings.Join(strings.Fields(node.ChildrenFiltered(".node-head").Text()), " ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_HeadRules_dt(t *testing.T) {
+	//   Glossary
+	//   /     \
+	//  Foo    Bar
+	//   |      |
+	//  dd     dd
+	doc, _ := LoadHtml(`
+		<h1>Glossary</h1>
+		<dl>
+			<dt>Foo</dt><dd>The first</dd>
+			<dt>Bar</dt><dd>The second</dd>
+		</dl>
+	`)
+	rules := append(HeadRules{{Selector: "dt", Rank: 1, Unwrap: "dl"}}, DefaultHeadRules...)
+	assert.Nil(t, rules.Validate())
+	Treeify(doc, rules)
+
+	assert.Equal(t, 0, doc.Find("dl").Length())
+	assert.Equal(t, 1, doc.Find(".node-depth-0").Length())
+	assert.Equal(t, 2, doc.Find(".node-depth-1").Length())
+	assert.Equal(t, 2, doc.Find(".node-depth-2").Length())
+	assert.Equal(t, "Bar", selText(doc.Find(".node-depth-1 > .node-head").Last()))
+	assert.Equal(t, "The second", selText(doc.Find(".node-depth-2").Last()))
+}
+
+func Test_HeadRules_bold_only(t *testing.T) {
+	// Title
+	//   |
+	//  p
+	doc, _ := LoadHtml(`
+		<p><strong>Title</strong></p>
+		<p><strong>Not</strong> a title</p>
+	`)
+	Treeify(doc, HeadRules{{Selector: "p", Match: "bold-only", Rank: 0}})
+
+	assert.Equal(t, 2, doc.Find(".node").Length())
+	assert.Equal(t, "Title", selText(doc.Find(".node-depth-0 > .node-head")))
+	assert.Equal(t, "Not a title", selText(doc.Find(".node-depth-1")))
+}
+
+func Test_HeadRules_Validate(t *testing.T) {
+	assert.NotNil(t, HeadRules{{Selector: "p[", Rank: 0}}.Validate())
+	assert.NotNil(t, HeadRules{{Selector: "p", Match: "italic-only"}}.Validate())
+	assert.Nil(t, DefaultHeadRules.Validate())
+}
+
+func Test_Normalize_sections(t *testing.T) {
+	//      H1
+	//     /  \
+	//    p    H2
+	//          |
+	//          p
+	doc, _ := LoadHtml(`
+		<section id="title" class="level1">
+			<h1>H1</h1>
+			<p>Hello World</p>
+			<section id="sub" class="level2 special">
+				<h2 class="unnumbered">H2</h2>
+				<p>Goodbye World</p>
+			</section>
+		</section>
+	`)
+	Normalize(doc, DefaultHeadRules)
+
+	assert.Equal(t, 0, doc.Find("section").Length())
+	assert.Equal(t, "title", doc.Find("h1").AttrOr("id", ""))
+	assert.Equal(t, "sub", doc.Find("h2").AttrOr("id", ""))
+	assert.Equal(t, "unnumbered level2 special", doc.Find("h2").AttrOr("class", ""))
+
+	Treeify(doc, DefaultHeadRules)
+	assert.Equal(t, 4, doc.Find(".node").Length())
+	assert.Equal(t, 1, doc.Find(".node-depth-0").Length())
+	assert.Equal(t, 2, doc.Find(".node-depth-1").Length())
+	assert.Equal(t, "H2", selText(doc.Find(".node-depth-1 > .node-head").Last()))
+	assert.Equal(t, "Goodbye World", selText(doc.Find(".node-depth-2")))
+}
+
+func Test_Normalize_leading_content(t *testing.T) {
+	doc, _ := LoadHtml(`
+		<div class="note">
+			<p>Preface</p>
+			<h2>H2</h2>
+		</div>
+		<blockquote><h3>Quoted</h3></blockquote>
+	`)
+	Normalize(doc, DefaultHeadRules)
+
+	assert.Equal(t, "Preface", selText(doc.Find("body > div.note")))
+	assert.Equal(t, 1, doc.Find("body > h2").Length())
+	assert.Equal(t, 1, doc.Find("blockquote > h3").Length())
+}